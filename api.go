@@ -20,20 +20,48 @@ package atomic
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 )
 
 type (
 	ApiConfig struct {
-		AccessToken string
-		Host        string
-		http        *http.Client
+		AccessToken       string
+		TokenSource       oauth2.TokenSource
+		TokenCache        TokenCache
+		Host              string
+		BaseURL           string
+		RootCAs           *x509.CertPool
+		Certificates      []tls.Certificate
+		UnixSocket        string
+		EndpointPool      *EndpointPool
+		Retry             RetryPolicy
+		IdempotencyKeys   bool
+		RateLimiter       *RateLimiter
+		Middleware        []Middleware
+		SkipValidation    bool
+		Logger            *slog.Logger
+		LogOptions        LogOptions
+		http              *http.Client
+		clientSecrets     []string
+		clientCredentials *clientcredentials.Config
+		refreshConfig     *oauth2.Config
+		refreshToken      *oauth2.Token
+		tokenNotify       func(*oauth2.Token)
+		tokens            *tokenSource
+		base              *url.URL
+		err               error
 	}
 
 	ApiBackend struct {
@@ -50,8 +78,10 @@ const (
 func New(opts ...ApiOption) *Client {
 	b := &ApiBackend{
 		ApiConfig{
-			Host: DefaultAPIHost,
-			http: http.DefaultClient,
+			Host:       DefaultAPIHost,
+			Retry:      DefaultRetryPolicy(),
+			LogOptions: DefaultLogOptions(),
+			http:       http.DefaultClient,
 		},
 	}
 
@@ -59,7 +89,10 @@ func New(opts ...ApiOption) *Client {
 		opt(&b.c)
 	}
 
-	return NewClient(b)
+	b.c.resolve()
+	b.c.tokens = b.c.newTokenSource()
+
+	return NewClient(Chain(b, b.c.Middleware...))
 }
 
 func WithHost(host string) ApiOption {
@@ -80,38 +113,210 @@ func WithHTTPClient(http *http.Client) ApiOption {
 	}
 }
 
-func WithClientCredentials(clientID, clientSecret string, scopes ...string) ApiOption {
-	return func(c *ApiConfig) {
-		cc := clientcredentials.Config{
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			Scopes:       scopes,
-			TokenURL:     "https://" + c.Host + "/oauth/token",
+func (b *ApiBackend) ExecContext(ctx context.Context, params RequestContainer, result Responder) error {
+	if !b.c.SkipValidation {
+		if err := ValidateParams(params); err != nil {
+			return err
 		}
+	}
+
+	ctx = b.withIdempotencyKey(ctx, params)
 
-		c.http = cc.Client(context.Background())
+	hooks := hooksFromContext(ctx)
+
+	op, _ := OperationFor(params)
+
+	refreshed := false
+
+	pool := b.c.EndpointPool
+
+	var instance string
+	if i := params.RequestParams().Instance; i != nil {
+		instance = *i
+	}
+
+	var ep, failed *endpoint
+
+	// endpoints skipped in a row because they could not be dialed
+	skipped := 0
+
+	// attempts that do not count against MaxAttempts: failovers past
+	// endpoints that could not be dialed and the resend with a refreshed token
+	free := 0
+
+	for attempt := 1; ; attempt++ {
+		actx := ctx
+
+		if pool != nil {
+			ep = pool.pick(instance, failed)
+			actx = contextWithEndpoint(ctx, ep)
+		}
+
+		req, err := b.NewRequest(actx, params)
+		if err != nil {
+			return transportError(err)
+		}
+
+		if err := hooks.request(req); err != nil {
+			return err
+		}
+
+		b.logRequest(ctx, req, attempt)
+
+		start := time.Now()
+
+		resp, body, err := b.do(req)
+
+		b.logResponse(ctx, req, resp, body, err, attempt, time.Since(start))
+
+		if err == nil {
+			hooks.response(req, resp)
+		}
+
+		failed = nil
+
+		if pool != nil && pool.observe(ep, resp, err) && pool.canFailover(req, op, err) {
+			failed = ep
+
+			// nothing reached the server, so try the next endpoint right away
+			// without using up an attempt
+			if isDialError(err) && skipped < len(pool.endpoints)-1 {
+				skipped++
+				free++
+				continue
+			}
+		}
+
+		skipped = 0
+
+		// a rejected token is refreshed and the request sent once more
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && !refreshed {
+			if ts := b.refreshSource(req, params.RequestParams()); ts != nil {
+				refreshed = true
+
+				_, rerr := ts.refresh(ctx, bearerToken(req.Header.Get("Authorization")))
+				if rerr == nil {
+					free++
+					continue
+				} else if !errors.Is(rerr, ErrTokenNotRefreshed) {
+					return transportError(rerr)
+				}
+			}
+		}
+
+		if delay, ok := b.c.Retry.retryDelay(attempt-free, req, op, resp, err); ok {
+			if err := sleepContext(req.Context(), delay); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			// no response arrived, but the attempts are still reported
+			captureResponse(ctx, &Response{Attempts: attempt})
+
+			return transportError(err)
+		}
+
+		return b.decodeResponse(ctx, resp, body, attempt, result)
 	}
 }
 
-func (b *ApiBackend) ExecContext(ctx context.Context, params RequestContainer, result Responder) error {
-	req, err := b.NewRequest(ctx, params)
-	if err != nil {
+// refreshSource returns the token source that authorized req, if it can
+// replace a rejected token and req can be sent again.
+func (b *ApiBackend) refreshSource(req *http.Request, params Params) *tokenSource {
+	if req.Header.Get("Authorization") == "" {
+		return nil
+	}
+
+	if !isRewindable(req) {
+		return nil
+	}
+
+	switch {
+	case params.TokenSource != nil:
+		ts, _ := params.TokenSource.(*tokenSource)
+		return ts
+	case params.AccessToken != "":
+		return nil
+	default:
+		return b.c.tokens
+	}
+}
+
+// transportError surfaces failed token exchanges as an Error so callers can
+// match them with errors.Is like any other API failure.
+func transportError(err error) error {
+	var rerr *oauth2.RetrieveError
+	if !errors.As(err, &rerr) || rerr.Response == nil {
 		return err
 	}
 
+	return Error{
+		Code:       rerr.ErrorCode,
+		Message:    rerr.ErrorDescription,
+		Status:     rerr.Response.Status,
+		StatusCode: rerr.Response.StatusCode,
+		Raw:        string(rerr.Body),
+		err:        err,
+	}
+}
+
+// do sends a single attempt, waiting on the rate limiter when one is set. The
+// body is read in full and the response body replaced with a fresh reader
+// over it, so hooks can inspect it without consuming it.
+func (b *ApiBackend) do(req *http.Request) (*http.Response, []byte, error) {
+	host, instance := req.URL.Host, req.Header.Get("Atomic-Instance")
+
+	if b.c.RateLimiter != nil {
+		if err := b.c.RateLimiter.Wait(req.Context(), host, instance); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	resp, err := b.c.http.Do(req)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
+	if b.c.RateLimiter != nil {
+		b.c.RateLimiter.Observe(host, instance, resp)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	return resp, body, nil
+}
+
+func (b *ApiBackend) decodeResponse(ctx context.Context, resp *http.Response, body []byte, attempts int, result Responder) error {
+	last := &Response{
+		Headers:    resp.Header,
+		Body:       body,
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get(RequestIDHeader),
+		Attempts:   attempts,
 	}
 
+	if result != nil {
+		result.SetLastResponse(last)
+	}
+
+	captureResponse(ctx, last)
+
 	if resp.StatusCode >= 400 {
-		e := Error{Status: resp.Status}
+		e := Error{
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			RequestID:  last.RequestID,
+		}
 
 		if len(body) > 0 {
 			// try the standard {code,message} shape first; if it fails or yields
@@ -127,7 +332,7 @@ func (b *ApiBackend) ExecContext(ctx context.Context, params RequestContainer, r
 		return e
 	}
 
-	if len(body) > 0 {
+	if len(body) > 0 && result != nil {
 		if err := json.NewDecoder(bytes.NewReader(body)).Decode(result.Response()); err != nil {
 			return err
 		}
@@ -139,16 +344,39 @@ func (b *ApiBackend) ExecContext(ctx context.Context, params RequestContainer, r
 func (b *ApiBackend) NewRequest(ctx context.Context, params RequestContainer) (*http.Request, error) {
 	reqParams := params.RequestParams()
 
-	path := fmt.Sprintf("https://%s/%s", b.c.Host, strings.TrimPrefix(params.Path(), "/"))
+	if b.c.err != nil {
+		return nil, b.c.err
+	}
+
+	path := b.c.url(ctx, params.Path())
 
-	req, err := http.NewRequest(params.Method(), path, params.Body())
+	body := params.Body()
+
+	req, err := http.NewRequestWithContext(ctx, params.Method(), path, body)
 	if err != nil {
 		return nil, err
 	}
 
+	// section readers are re-creatable but unknown to net/http, so wire up
+	// GetBody by hand to keep them retryable
+	switch rb := body.(type) {
+	case *io.SectionReader:
+		req.ContentLength = rb.Size()
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(rb, 0, rb.Size())), nil
+		}
+	case *streamBody:
+		// a zero length with a body is sent chunked
+		req.ContentLength = max(rb.size, 0)
+		req.GetBody = rb.reopen
+	}
+
 	req.Header.Add("Content-Type", params.ContentType())
 
-	authorization := "Bearer " + b.c.AccessToken
+	authorization, err := b.authorization(ctx, reqParams)
+	if err != nil {
+		return nil, err
+	}
 
 	if params != nil {
 		if reqParams.Context != nil {
@@ -159,6 +387,10 @@ func (b *ApiBackend) NewRequest(ctx context.Context, params RequestContainer) (*
 			req.Header.Add("Atomic-Instance", strings.TrimSpace(*reqParams.Instance))
 		}
 
+		if key := idempotencyKeyFromContext(ctx, reqParams); key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+
 		for k, v := range reqParams.Headers {
 			for _, line := range v {
 				// Use Set to override the default value possibly set before
@@ -166,12 +398,44 @@ func (b *ApiBackend) NewRequest(ctx context.Context, params RequestContainer) (*
 			}
 		}
 
-		if !reqParams.NoAuth && b.c.AccessToken != "" {
+		if authorization != "" {
 			req.Header.Add("Authorization", authorization)
 		}
-	} else if b.c.AccessToken != "" {
+	} else if authorization != "" {
 		req.Header.Add("Authorization", authorization)
 	}
 
 	return req, nil
 }
+
+// authorization resolves the Authorization header of a request: credentials
+// in the params override the client's token source and access token.
+func (b *ApiBackend) authorization(ctx context.Context, params Params) (string, error) {
+	var tok *oauth2.Token
+	var err error
+
+	switch {
+	case params.NoAuth:
+		return "", nil
+	case params.TokenSource != nil:
+		if ts, ok := params.TokenSource.(*tokenSource); ok {
+			tok, err = ts.token(ctx)
+		} else {
+			tok, err = params.TokenSource.Token()
+		}
+	case params.AccessToken != "":
+		return "Bearer " + params.AccessToken, nil
+	case b.c.tokens != nil:
+		tok, err = b.c.tokens.token(ctx)
+	case b.c.AccessToken != "":
+		return "Bearer " + b.c.AccessToken, nil
+	default:
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return tok.Type() + " " + tok.AccessToken, nil
+}
@@ -20,6 +20,7 @@ package atomic
 import (
 	"context"
 	"fmt"
+	"iter"
 	"net/http"
 
 	"github.com/libatomic/atomic/pkg/atomic"
@@ -111,3 +112,9 @@ func (c *Client) ApplicationList(ctx context.Context, params *atomic.Application
 
 	return resp.Value(), nil
 }
+
+func (c *Client) ApplicationListAll(ctx context.Context, params *atomic.ApplicationListInput, opts ...PageOption) iter.Seq2[*atomic.Application, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*atomic.Application, error) {
+		return c.ApplicationList(ctx, params)
+	})
+}
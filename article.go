@@ -20,6 +20,7 @@ package atomic
 import (
 	"context"
 	"fmt"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -106,3 +107,9 @@ func (c *Client) ArticleList(ctx context.Context, params *ArticleListInput) ([]*
 
 	return resp.Value(), nil
 }
+
+func (c *Client) ArticleListAll(ctx context.Context, params *ArticleListInput, opts ...PageOption) iter.Seq2[*Article, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*Article, error) {
+		return c.ArticleList(ctx, params)
+	})
+}
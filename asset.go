@@ -18,14 +18,10 @@
 package atomic
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io"
-	"mime/multipart"
-	"net/textproto"
-	"strconv"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -69,35 +65,14 @@ func (c *Client) AssetCreate(ctx context.Context, params *AssetCreateInput) (*As
 		return nil, errors.New("filename is required")
 	}
 
-	var body bytes.Buffer
-
-	writer := multipart.NewWriter(&body)
-
-	h := make(textproto.MIMEHeader)
-	h.Set("Content-Disposition",
-		fmt.Sprintf(`form-data; name="%s"; filename="%s"`, "file", params.Filename))
-	h.Set("Content-Type", params.MimeType)
-	h.Set("Content-Length", strconv.FormatInt(params.Size, 10))
-
-	part, err := writer.CreatePart(h)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create multipart part: %w", err)
-	}
-
-	if _, err := io.Copy(part, params.Payload); err != nil {
-		return nil, fmt.Errorf("failed to copy file to multipart writer: %w", err)
-	}
-
-	if err := writer.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close multipart writer: %w", err)
-	}
+	upload := newMultipartUpload(ctx, "file", params.Filename, params.MimeType, params.Size, params.Payload)
 
 	if err := c.Backend.ExecContext(
 		ctx,
 		NewRequest(ctx, AssetCreatePath, params).Post().
-			WithContentType(writer.FormDataContentType()).
+			WithContentType(upload.ContentType()).
 			WithEncoding(ParamsEncodingQuery).
-			WithBody(&body),
+			WithBodyFunc(upload.Body),
 		&resp); err != nil {
 		return nil, err
 	}
@@ -157,3 +132,9 @@ func (c *Client) AssetList(ctx context.Context, params *AssetListInput) ([]*Asse
 
 	return resp.Value(), nil
 }
+
+func (c *Client) AssetListAll(ctx context.Context, params *AssetListInput, opts ...PageOption) iter.Seq2[*Asset, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*Asset, error) {
+		return c.AssetList(ctx, params)
+	})
+}
@@ -0,0 +1,419 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+type (
+	// AssetUpload is a resumable upload session; the payload is sent in
+	// numbered parts of ChunkSize bytes (the last one may be shorter) and
+	// finalized into an Asset.
+	AssetUpload struct {
+		ID        string            `json:"id"`
+		Filename  string            `json:"filename"`
+		MimeType  string            `json:"mime_type"`
+		Size      int64             `json:"size"`
+		ChunkSize int64             `json:"chunk_size"`
+		Parts     []AssetUploadPart `json:"parts,omitempty"`
+	}
+
+	AssetUploadPart struct {
+		Number   int    `json:"number"`
+		Size     int64  `json:"size"`
+		Checksum string `json:"checksum"`
+	}
+
+	AssetUploadCreateInput struct {
+		Filename  string `json:"filename"`
+		MimeType  string `json:"mime_type,omitempty"`
+		Size      int64  `json:"size"`
+		ChunkSize int64  `json:"chunk_size"`
+	}
+
+	AssetUploadGetInput struct {
+		UploadID string `json:"-"`
+	}
+
+	AssetUploadPartInput struct {
+		UploadID string `json:"-"`
+		Number   int    `json:"-"`
+		Data     []byte `json:"-"`
+	}
+
+	AssetUploadCompleteInput struct {
+		UploadID string            `json:"-"`
+		Parts    []AssetUploadPart `json:"parts"`
+	}
+
+	// AssetCreateResumableInput describes a payload to upload in chunks. When
+	// StateFile is set, completed parts are recorded there so an interrupted
+	// upload resumes where it stopped, even after a process restart.
+	AssetCreateResumableInput struct {
+		Payload   io.ReaderAt
+		Filename  string
+		MimeType  string
+		Size      int64
+		ChunkSize int64
+		StateFile string
+		// Progress is called after every part with the bytes confirmed so far
+		Progress func(sent, total int64)
+	}
+
+	assetUploadState struct {
+		UploadID string `json:"upload_id"`
+		Filename string `json:"filename"`
+		Size     int64  `json:"size"`
+		// Requested is the chunk size asked for, ChunkSize the one the
+		// server settled on
+		Requested int64 `json:"requested_chunk_size"`
+		ChunkSize int64 `json:"chunk_size"`
+		// Parts maps the confirmed parts to the hex sha256 of their data
+		Parts map[int]string `json:"parts"`
+	}
+)
+
+const (
+	AssetUploadCreatePath   = "/api/1.0.0/assets/uploads"
+	AssetUploadGetPath      = "/api/1.0.0/assets/uploads/%s"
+	AssetUploadPartPath     = "/api/1.0.0/assets/uploads/%s/parts/%s"
+	AssetUploadCompletePath = "/api/1.0.0/assets/uploads/%s/complete"
+
+	// ChecksumHeader carries the hex sha256 of an upload part.
+	ChecksumHeader = "X-Checksum-Sha256"
+
+	DefaultChunkSize = 8 << 20
+)
+
+var (
+	ErrChecksumMismatch = errors.New("upload part checksum mismatch")
+)
+
+func (i AssetUploadCreateInput) Validate() error {
+	return validation.ValidateStruct(&i,
+		validation.Field(&i.Filename, validation.Required),
+		validation.Field(&i.Size, validation.Required, validation.Min(int64(1))),
+		validation.Field(&i.ChunkSize, validation.Required, validation.Min(int64(1))),
+	)
+}
+
+func (i AssetUploadGetInput) Validate() error {
+	return validation.ValidateStruct(&i,
+		validation.Field(&i.UploadID, validation.Required),
+	)
+}
+
+func (i AssetUploadPartInput) Validate() error {
+	return validation.ValidateStruct(&i,
+		validation.Field(&i.UploadID, validation.Required),
+		validation.Field(&i.Number, validation.Required, validation.Min(1)),
+		validation.Field(&i.Data, validation.Required),
+	)
+}
+
+func (i AssetUploadCompleteInput) Validate() error {
+	return validation.ValidateStruct(&i,
+		validation.Field(&i.UploadID, validation.Required),
+		validation.Field(&i.Parts, validation.Required),
+	)
+}
+
+func (c *Client) AssetUploadCreate(ctx context.Context, params *AssetUploadCreateInput) (*AssetUpload, error) {
+	var resp ResponseProxy[AssetUpload]
+
+	if err := c.Backend.ExecContext(
+		ctx,
+		NewRequest(ctx, AssetUploadCreatePath, params).Post(),
+		&resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Pointer(), nil
+}
+
+func (c *Client) AssetUploadGet(ctx context.Context, params *AssetUploadGetInput) (*AssetUpload, error) {
+	var resp ResponseProxy[AssetUpload]
+
+	path := fmt.Sprintf(AssetUploadGetPath, params.UploadID)
+
+	if err := c.Backend.ExecContext(
+		ctx,
+		NewRequest(ctx, path, params).Get(),
+		&resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Pointer(), nil
+}
+
+// AssetUploadPart sends a single part; the server echoes the checksum it
+// computed, which is verified against the one sent.
+func (c *Client) AssetUploadPart(ctx context.Context, params *AssetUploadPartInput) (*AssetUploadPart, error) {
+	var resp ResponseProxy[AssetUploadPart]
+
+	path := fmt.Sprintf(AssetUploadPartPath, params.UploadID, strconv.Itoa(params.Number))
+
+	sum := checksum(params.Data)
+
+	p := ParamsFromContext(ctx)
+	p.Headers = p.Headers.Clone()
+	if p.Headers == nil {
+		p.Headers = make(map[string][]string)
+	}
+	p.Headers.Set(ChecksumHeader, sum)
+	ctx = ContextWithParams(ctx, p)
+
+	if err := c.Backend.ExecContext(
+		ctx,
+		NewRequest(ctx, path, params).Put().
+			WithContentType("application/octet-stream").
+			WithBody(bytes.NewReader(params.Data)),
+		&resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Pointer().Checksum != sum {
+		return nil, fmt.Errorf("part %d: %w", params.Number, ErrChecksumMismatch)
+	}
+
+	return resp.Pointer(), nil
+}
+
+func (c *Client) AssetUploadComplete(ctx context.Context, params *AssetUploadCompleteInput) (*Asset, error) {
+	var resp ResponseProxy[Asset]
+
+	path := fmt.Sprintf(AssetUploadCompletePath, params.UploadID)
+
+	if err := c.Backend.ExecContext(
+		ctx,
+		NewRequest(ctx, path, params).Post(),
+		&resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Pointer(), nil
+}
+
+// AssetCreateResumable uploads the payload in checksummed chunks and
+// finalizes it into an Asset. Parts already confirmed by the server for the
+// session recorded in StateFile are skipped while the payload still hashes to
+// the recorded checksum, and sent again otherwise; the state file is removed
+// once the asset is created.
+func (c *Client) AssetCreateResumable(ctx context.Context, params *AssetCreateResumableInput) (*Asset, error) {
+	if params.Payload == nil {
+		return nil, errors.New("payload is required")
+	}
+
+	if params.Filename == "" {
+		return nil, errors.New("filename is required")
+	}
+
+	if params.Size <= 0 {
+		return nil, errors.New("size is required")
+	}
+
+	chunkSize := params.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	state, err := loadAssetUploadState(params.StateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// a state file for a different payload can't be resumed; the server may
+	// have picked another chunk size, so compare against the one requested
+	if state.Filename != params.Filename || state.Size != params.Size || state.Requested != chunkSize || state.ChunkSize <= 0 {
+		state = assetUploadState{}
+	}
+
+	requested := chunkSize
+	if state.UploadID != "" {
+		chunkSize = state.ChunkSize
+	}
+
+	done := make(map[int]string)
+
+	if state.UploadID != "" {
+		upload, err := c.AssetUploadGet(ctx, &AssetUploadGetInput{UploadID: state.UploadID})
+		switch {
+		case errors.Is(err, ErrNotFound):
+			state = assetUploadState{}
+			chunkSize = requested
+		case err != nil:
+			return nil, err
+		default:
+			// only trust parts both sides agree on
+			for _, part := range upload.Parts {
+				if sum, ok := state.Parts[part.Number]; ok && sum == part.Checksum {
+					done[part.Number] = sum
+				}
+			}
+		}
+	}
+
+	if state.UploadID == "" {
+		upload, err := c.AssetUploadCreate(ctx, &AssetUploadCreateInput{
+			Filename:  params.Filename,
+			MimeType:  params.MimeType,
+			Size:      params.Size,
+			ChunkSize: chunkSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if upload.ChunkSize > 0 {
+			chunkSize = upload.ChunkSize
+		}
+
+		state = assetUploadState{
+			UploadID:  upload.ID,
+			Filename:  params.Filename,
+			Size:      params.Size,
+			Requested: requested,
+			ChunkSize: chunkSize,
+		}
+	}
+
+	state.Parts = done
+
+	if err := state.save(params.StateFile); err != nil {
+		return nil, err
+	}
+
+	count := int((params.Size + chunkSize - 1) / chunkSize)
+	parts := make([]AssetUploadPart, 0, count)
+
+	var sent int64
+
+	for n := 1; n <= count; n++ {
+		off := int64(n-1) * chunkSize
+		size := min(chunkSize, params.Size-off)
+
+		data := make([]byte, size)
+		read, err := params.Payload.ReadAt(data, off)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read part %d: %w", n, err)
+		}
+
+		// only the final part may come up short, when the payload is
+		// smaller than Size
+		if int64(read) < size && n < count {
+			return nil, fmt.Errorf("failed to read part %d: %w", n, io.ErrUnexpectedEOF)
+		}
+		data = data[:read]
+
+		// the payload may have changed since the part was sent
+		if sum, ok := done[n]; ok && sum == checksum(data) {
+			parts = append(parts, AssetUploadPart{Number: n, Size: int64(len(data)), Checksum: sum})
+			sent += int64(len(data))
+			continue
+		}
+
+		part, err := c.AssetUploadPart(ctx, &AssetUploadPartInput{
+			UploadID: state.UploadID,
+			Number:   n,
+			Data:     data,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, *part)
+
+		state.Parts[n] = part.Checksum
+		if err := state.save(params.StateFile); err != nil {
+			return nil, err
+		}
+
+		sent += int64(len(data))
+		if params.Progress != nil {
+			params.Progress(sent, params.Size)
+		}
+	}
+
+	asset, err := c.AssetUploadComplete(ctx, &AssetUploadCompleteInput{
+		UploadID: state.UploadID,
+		Parts:    parts,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if params.StateFile != "" {
+		if err := os.Remove(params.StateFile); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return asset, nil
+}
+
+func loadAssetUploadState(path string) (assetUploadState, error) {
+	var state assetUploadState
+
+	if path == "" {
+		return state, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("invalid upload state file %s: %w", path, err)
+	}
+
+	return state, nil
+}
+
+// save persists the state; a crash never leaves a truncated state file behind.
+func (s assetUploadState) save(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path, data, 0o600)
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
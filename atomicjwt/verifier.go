@@ -0,0 +1,380 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package atomicjwt verifies atomic access tokens locally against the JSON
+// Web Key Set of the issuing instance, so services don't need to call
+// AccessTokenGet for every request they receive.
+package atomicjwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+type (
+	// Claims are the verified claims of an access token.
+	Claims struct {
+		jwt.Claims
+		ClientID string `json:"client_id,omitempty"`
+		Instance string `json:"instance_id,omitempty"`
+		Scope    Scopes `json:"scope,omitempty"`
+	}
+
+	// Scopes accepts both the space delimited string and the array form.
+	Scopes []string
+
+	Verifier struct {
+		url       string
+		client    *http.Client
+		issuer    string
+		anyIssuer bool
+		audience  []string
+		scopes    []string
+		leeway    time.Duration
+		interval  time.Duration
+		minReload time.Duration
+		now       func() time.Time
+
+		mu      sync.RWMutex
+		keys    jose.JSONWebKeySet
+		fetched time.Time
+		reload  sync.Mutex
+		stop    context.CancelFunc
+	}
+
+	Option func(*Verifier)
+)
+
+const (
+	DefaultRefreshInterval = time.Hour
+	DefaultLeeway          = time.Minute
+)
+
+var (
+	ErrInvalidToken      = errors.New("invalid token")
+	ErrUnknownKey        = errors.New("token signed by unknown key")
+	ErrExpired           = errors.New("token expired")
+	ErrInsufficientScope = errors.New("token is missing required scope")
+	ErrIssuerRequired    = errors.New("atomicjwt: an issuer is required, see WithIssuer")
+
+	// only asymmetric algorithms are accepted so a public key can never be
+	// used as an HMAC secret
+	allowedAlgorithms = []jose.SignatureAlgorithm{
+		jose.RS256, jose.RS384, jose.RS512,
+		jose.PS256, jose.PS384, jose.PS512,
+		jose.ES256, jose.ES384, jose.ES512,
+		jose.EdDSA,
+	}
+)
+
+// JWKSURL returns the key set URL of an API host or base URL.
+func JWKSURL(host string) string {
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+
+	return strings.TrimSuffix(host, "/") + "/.well-known/jwks.json"
+}
+
+// WithIssuer requires the iss claim to equal issuer. New fails without it,
+// unless WithAnyIssuer is given.
+func WithIssuer(issuer string) Option {
+	return func(v *Verifier) {
+		v.issuer = issuer
+	}
+}
+
+// WithAnyIssuer accepts tokens from any issuer whose keys are in the key set.
+// Only use it when the key set belongs to a single issuer; otherwise a token
+// minted by another instance sharing the keys would be accepted.
+func WithAnyIssuer() Option {
+	return func(v *Verifier) {
+		v.anyIssuer = true
+	}
+}
+
+// WithAudience requires the aud claim to contain at least one of aud.
+func WithAudience(aud ...string) Option {
+	return func(v *Verifier) {
+		v.audience = aud
+	}
+}
+
+// WithRequiredScopes requires every token to carry all of scopes.
+func WithRequiredScopes(scopes ...string) Option {
+	return func(v *Verifier) {
+		v.scopes = scopes
+	}
+}
+
+// WithLeeway sets the clock skew allowed on exp, nbf and iat.
+func WithLeeway(d time.Duration) Option {
+	return func(v *Verifier) {
+		v.leeway = d
+	}
+}
+
+// WithRefreshInterval sets how often keys are reloaded in the background;
+// zero disables background reloads.
+func WithRefreshInterval(d time.Duration) Option {
+	return func(v *Verifier) {
+		v.interval = d
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(v *Verifier) {
+		v.client = client
+	}
+}
+
+// New loads the key set at jwksURL and keeps it current in the background
+// until ctx is done or Close is called. Tokens signed by an unknown key also
+// trigger a reload, at most once per minute, to pick up rotated keys early.
+func New(ctx context.Context, jwksURL string, opts ...Option) (*Verifier, error) {
+	v := &Verifier{
+		url:       jwksURL,
+		client:    http.DefaultClient,
+		leeway:    DefaultLeeway,
+		interval:  DefaultRefreshInterval,
+		minReload: time.Minute,
+		now:       time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	if v.issuer == "" && !v.anyIssuer {
+		return nil, ErrIssuerRequired
+	}
+
+	if err := v.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	ctx, v.stop = context.WithCancel(ctx)
+
+	if v.interval > 0 {
+		go v.run(ctx)
+	}
+
+	return v, nil
+}
+
+// Close stops background key reloads.
+func (v *Verifier) Close() {
+	v.stop()
+}
+
+// Refresh reloads the key set.
+func (v *Verifier) Refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to load jwks: %s", resp.Status)
+	}
+
+	var keys jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return fmt.Errorf("failed to load jwks: %w", err)
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetched = v.now()
+	v.mu.Unlock()
+
+	return nil
+}
+
+// Verify checks the signature and claims of a token and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	tok, err := jwt.ParseSigned(token, allowedAlgorithms)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if len(tok.Headers) != 1 {
+		return nil, fmt.Errorf("%w: expected a single signature", ErrInvalidToken)
+	}
+
+	header := tok.Headers[0]
+
+	key, err := v.key(ctx, header.KeyID, header.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+
+	if err := tok.Claims(key.Key, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if err := v.validate(&claims); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+func (v *Verifier) validate(claims *Claims) error {
+	if claims.Expiry == nil {
+		return fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+
+	err := claims.ValidateWithLeeway(jwt.Expected{
+		Issuer: v.issuer,
+		Time:   v.now(),
+	}, v.leeway)
+	if errors.Is(err, jwt.ErrExpired) {
+		return fmt.Errorf("%w: %w", ErrExpired, err)
+	} else if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if len(v.audience) > 0 && !slices.ContainsFunc(v.audience, claims.Audience.Contains) {
+		return fmt.Errorf("%w: %w", ErrInvalidToken, jwt.ErrInvalidAudience)
+	}
+
+	for _, s := range v.scopes {
+		if !claims.HasScope(s) {
+			return fmt.Errorf("%w: %s", ErrInsufficientScope, s)
+		}
+	}
+
+	return nil
+}
+
+// key finds the signing key, reloading the set once when the key id is not
+// known yet.
+func (v *Verifier) key(ctx context.Context, kid, alg string) (*jose.JSONWebKey, error) {
+	if key := v.lookup(kid, alg); key != nil {
+		return key, nil
+	}
+
+	v.reload.Lock()
+	defer v.reload.Unlock()
+
+	if key := v.lookup(kid, alg); key != nil {
+		return key, nil
+	}
+
+	v.mu.RLock()
+	fetched := v.fetched
+	v.mu.RUnlock()
+
+	if v.now().Sub(fetched) >= v.minReload {
+		if err := v.Refresh(ctx); err != nil {
+			return nil, err
+		}
+
+		if key := v.lookup(kid, alg); key != nil {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+}
+
+func (v *Verifier) lookup(kid, alg string) *jose.JSONWebKey {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	for _, key := range v.keys.Keys {
+		if key.KeyID != kid || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+
+		if key.Algorithm != "" && key.Algorithm != alg {
+			continue
+		}
+
+		if !key.IsPublic() {
+			continue
+		}
+
+		return &key
+	}
+
+	return nil
+}
+
+func (v *Verifier) run(ctx context.Context) {
+	t := time.NewTicker(v.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			// a failed reload keeps the current keys until the next tick
+			v.Refresh(ctx)
+		}
+	}
+}
+
+// UserID is the subject of the token.
+func (c Claims) UserID() string {
+	return c.Subject
+}
+
+func (c Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scope, scope)
+}
+
+func (s *Scopes) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = strings.Fields(str)
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	*s = list
+
+	return nil
+}
+
+func (s Scopes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strings.Join(s, " "))
+}
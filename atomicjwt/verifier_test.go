@@ -0,0 +1,165 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomicjwt_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libatomic/atomic-go/atomicjwt"
+	"github.com/libatomic/atomic-go/atomictest"
+)
+
+const issuer = "https://api.atomic.com"
+
+func newVerifier(t *testing.T, opts ...atomicjwt.Option) (*atomicjwt.Verifier, *atomictest.JWKSServer) {
+	t.Helper()
+
+	srv, err := atomictest.NewJWKSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+
+	v, err := atomicjwt.New(context.Background(), srv.JWKSURL(), append([]atomicjwt.Option{
+		atomicjwt.WithHTTPClient(srv.Client()),
+		atomicjwt.WithIssuer(issuer),
+		atomicjwt.WithAudience("my-service"),
+		atomicjwt.WithLeeway(0),
+		atomicjwt.WithRefreshInterval(0),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(v.Close)
+
+	return v, srv
+}
+
+func claims(overrides map[string]any) map[string]any {
+	now := time.Now()
+
+	c := map[string]any{
+		"iss":         issuer,
+		"sub":         "user-1",
+		"aud":         []string{"my-service"},
+		"exp":         now.Add(time.Hour).Unix(),
+		"iat":         now.Unix(),
+		"instance_id": "instance-1",
+		"scope":       "articles:read users:read",
+	}
+
+	for k, v := range overrides {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+
+	return c
+}
+
+func sign(t *testing.T, srv *atomictest.JWKSServer, c map[string]any) string {
+	t.Helper()
+
+	tok, err := srv.Sign(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tok
+}
+
+func TestVerify(t *testing.T) {
+	v, srv := newVerifier(t, atomicjwt.WithRequiredScopes("articles:read"))
+
+	c, err := v.Verify(context.Background(), sign(t, srv, claims(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.UserID() != "user-1" || c.Instance != "instance-1" || !c.HasScope("users:read") {
+		t.Errorf("claims = %+v", c)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	v, srv := newVerifier(t, atomicjwt.WithRequiredScopes("articles:read"))
+
+	// a token from an issuer whose keys the verifier doesn't know
+	other, err := atomictest.NewJWKSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// move it past the verifier's key ids
+	if err := other.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", sign(t, srv, claims(map[string]any{"exp": time.Now().Add(-time.Minute).Unix()})), atomicjwt.ErrExpired},
+		{"missing exp", sign(t, srv, claims(map[string]any{"exp": nil})), atomicjwt.ErrInvalidToken},
+		{"wrong audience", sign(t, srv, claims(map[string]any{"aud": []string{"other-service"}})), atomicjwt.ErrInvalidToken},
+		{"wrong issuer", sign(t, srv, claims(map[string]any{"iss": "https://evil.example.com"})), atomicjwt.ErrInvalidToken},
+		{"missing scope", sign(t, srv, claims(map[string]any{"scope": "users:read"})), atomicjwt.ErrInsufficientScope},
+		{"unknown kid", sign(t, other, claims(nil)), atomicjwt.ErrUnknownKey},
+		{"malformed", "not.a.token", atomicjwt.ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v.Verify(context.Background(), tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIssuerRequired(t *testing.T) {
+	srv, err := atomictest.NewJWKSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	if _, err := atomicjwt.New(context.Background(), srv.JWKSURL(), atomicjwt.WithHTTPClient(srv.Client())); !errors.Is(err, atomicjwt.ErrIssuerRequired) {
+		t.Fatalf("err = %v", err)
+	}
+
+	v, err := atomicjwt.New(context.Background(), srv.JWKSURL(),
+		atomicjwt.WithHTTPClient(srv.Client()),
+		atomicjwt.WithAnyIssuer(),
+		atomicjwt.WithRefreshInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+
+	if _, err := v.Verify(context.Background(), sign(t, srv, claims(map[string]any{"iss": "https://other.example.com"}))); err != nil {
+		t.Errorf("any issuer: %v", err)
+	}
+}
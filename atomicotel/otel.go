@@ -0,0 +1,191 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package atomicotel instruments atomic clients with OpenTelemetry traces and
+// metrics. Install it with atomic.WithMiddleware(atomicotel.Middleware()); it
+// uses the global providers by default, which are no-ops until configured.
+package atomicotel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libatomic/atomic-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	Option func(*config)
+
+	config struct {
+		tracerProvider trace.TracerProvider
+		meterProvider  metric.MeterProvider
+		propagator     propagation.TextMapPropagator
+	}
+)
+
+const (
+	ScopeName = "github.com/libatomic/atomic-go/atomicotel"
+
+	OperationKey  = attribute.Key("atomic.operation")
+	InstanceKey   = attribute.Key("atomic.instance")
+	RetryCountKey = attribute.Key("atomic.retry_count")
+	MethodKey     = attribute.Key("http.request.method")
+	TemplateKey   = attribute.Key("url.template")
+	StatusKey     = attribute.Key("http.response.status_code")
+	ErrorTypeKey  = attribute.Key("error.type")
+)
+
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = tp
+	}
+}
+
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = mp
+	}
+}
+
+// WithPropagator sets the propagator used to inject trace context headers,
+// the global one (W3C trace context by convention) is used by default.
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = p
+	}
+}
+
+// Middleware starts a client span per call named after the operation (e.g.
+// "atomic.UserGet"), injects the trace context into every attempt and records
+// latency and error metrics.
+func Middleware(opts ...Option) atomic.Middleware {
+	cfg := config{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+		propagator:     otel.GetTextMapPropagator(),
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	tracer := cfg.tracerProvider.Tracer(ScopeName)
+	meter := cfg.meterProvider.Meter(ScopeName)
+
+	// instrument creation only fails on invalid names, which are constant here
+	duration, _ := meter.Float64Histogram(
+		"atomic.client.request.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of atomic API calls, including retries."),
+	)
+
+	failures, _ := meter.Int64Counter(
+		"atomic.client.request.errors",
+		metric.WithUnit("{error}"),
+		metric.WithDescription("Number of failed atomic API calls."),
+	)
+
+	return func(next atomic.Backend) atomic.Backend {
+		return atomic.BackendFunc(func(ctx context.Context, params atomic.RequestContainer, result atomic.Responder) error {
+			name, template := "atomic.Request", params.Path()
+			template, _, _ = strings.Cut(template, "?")
+
+			attrs := []attribute.KeyValue{
+				MethodKey.String(params.Method()),
+			}
+
+			if op, ok := atomic.OperationFor(params); ok {
+				name, template = "atomic."+op.Name, op.Template()
+				attrs = append(attrs, OperationKey.String(op.Name))
+			}
+
+			attrs = append(attrs, TemplateKey.String(template))
+
+			if inst := params.RequestParams().Instance; inst != nil {
+				attrs = append(attrs, InstanceKey.String(strings.TrimSpace(*inst)))
+			}
+
+			ctx, span := tracer.Start(ctx, name,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(attrs...))
+			defer span.End()
+
+			var (
+				attempts int
+				status   int
+				start    = time.Now()
+				spanCtx  = ctx
+			)
+
+			ctx = atomic.ContextWithRequestHook(ctx, func(req *http.Request) error {
+				attempts++
+				cfg.propagator.Inject(spanCtx, propagation.HeaderCarrier(req.Header))
+				return nil
+			})
+
+			ctx = atomic.ContextWithResponseHook(ctx, func(_ *http.Request, resp *http.Response) {
+				status = resp.StatusCode
+			})
+
+			err := next.ExecContext(ctx, params, result)
+
+			if status != 0 {
+				attrs = append(attrs, StatusKey.Int(status))
+				span.SetAttributes(StatusKey.Int(status))
+			}
+
+			if attempts > 1 {
+				span.SetAttributes(RetryCountKey.Int(attempts - 1))
+			}
+
+			if err != nil {
+				attrs = append(attrs, ErrorTypeKey.String(errorType(err)))
+
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+
+				failures.Add(spanCtx, 1, metric.WithAttributes(attrs...))
+			}
+
+			duration.Record(spanCtx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+
+			return err
+		})
+	}
+}
+
+// errorType keeps the attribute low-cardinality: the status code for API
+// errors, the Go type otherwise.
+func errorType(err error) string {
+	var apiErr atomic.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode != 0 {
+		return strconv.Itoa(apiErr.StatusCode)
+	}
+
+	return fmt.Sprintf("%T", err)
+}
@@ -0,0 +1,212 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomicotel_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/libatomic/atomic-go"
+	"github.com/libatomic/atomic-go/atomicotel"
+	types "github.com/libatomic/atomic/pkg/atomic"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const userID = "0b0f5d6c-4a8e-4c1e-9b51-7f3d2d1f6a01"
+
+type harness struct {
+	client *atomic.Client
+	spans  *tracetest.InMemoryExporter
+	reader *sdkmetric.ManualReader
+	parent []string
+}
+
+func newHarness(t *testing.T, h http.HandlerFunc) *harness {
+	t.Helper()
+
+	hs := &harness{
+		spans:  tracetest.NewInMemoryExporter(),
+		reader: sdkmetric.NewManualReader(),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hs.parent = append(hs.parent, r.Header.Get("Traceparent"))
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(hs.spans))
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(hs.reader))
+
+	hs.client = atomic.New(
+		atomic.WithBaseURL(srv.URL),
+		atomic.WithToken("test-token"),
+		atomic.WithRetryPolicy(atomic.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+		atomic.WithMiddleware(atomicotel.Middleware(
+			atomicotel.WithTracerProvider(tp),
+			atomicotel.WithMeterProvider(mp),
+			atomicotel.WithPropagator(propagation.TraceContext{}),
+		)),
+	)
+
+	return hs
+}
+
+func (h *harness) metrics(t *testing.T) map[string]metricdata.Aggregation {
+	t.Helper()
+
+	var rm metricdata.ResourceMetrics
+	if err := h.reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			out[m.Name] = m.Data
+		}
+	}
+
+	return out
+}
+
+func userGetInput(t *testing.T) *atomic.UserGetInput {
+	t.Helper()
+
+	var id types.ID
+	if err := json.Unmarshal([]byte(strconv.Quote(userID)), &id); err != nil {
+		t.Fatal(err)
+	}
+
+	return &atomic.UserGetInput{UserID: &id}
+}
+
+func attrs(kvs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	out := make(map[attribute.Key]attribute.Value)
+	for _, kv := range kvs {
+		out[kv.Key] = kv.Value
+	}
+
+	return out
+}
+
+func TestMiddlewareSpan(t *testing.T) {
+	n := 0
+	h := newHarness(t, func(w http.ResponseWriter, r *http.Request) {
+		if n++; n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"id": userID})
+	})
+
+	if _, err := h.client.UserGet(context.Background(), userGetInput(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := h.spans.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+
+	span := spans[0]
+	a := attrs(span.Attributes)
+
+	if span.Name != "atomic.UserGet" {
+		t.Errorf("name = %q", span.Name)
+	}
+
+	if got := a[atomicotel.TemplateKey].AsString(); got != "/api/1.0.0/users/{user_id}" {
+		t.Errorf("url.template = %q", got)
+	}
+
+	if a[atomicotel.OperationKey].AsString() != "UserGet" || a[atomicotel.MethodKey].AsString() != http.MethodGet {
+		t.Errorf("attributes = %v", span.Attributes)
+	}
+
+	if a[atomicotel.StatusKey].AsInt64() != http.StatusOK || a[atomicotel.RetryCountKey].AsInt64() != 1 {
+		t.Errorf("attributes = %v", span.Attributes)
+	}
+
+	// every attempt carries the span's trace context
+	want := span.SpanContext.TraceID().String()
+	for _, p := range h.parent {
+		if len(p) < 35 || p[3:35] != want {
+			t.Errorf("traceparent = %q, want trace %s", p, want)
+		}
+	}
+
+	m := h.metrics(t)
+
+	hist, ok := m["atomic.client.request.duration"].(metricdata.Histogram[float64])
+	if !ok || len(hist.DataPoints) != 1 || hist.DataPoints[0].Count != 1 {
+		t.Errorf("duration = %#v", m["atomic.client.request.duration"])
+	}
+
+	if _, ok := m["atomic.client.request.errors"]; ok {
+		t.Error("a successful call counted as an error")
+	}
+}
+
+func TestMiddlewareError(t *testing.T) {
+	h := newHarness(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{"code": "not_found"})
+	})
+
+	if _, err := h.client.UserGet(context.Background(), userGetInput(t)); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	span := h.spans.GetSpans()[0]
+
+	if span.Status.Code != codes.Error || len(span.Events) == 0 {
+		t.Errorf("status = %v, events = %v", span.Status, span.Events)
+	}
+
+	sum, ok := h.metrics(t)["atomic.client.request.errors"].(metricdata.Sum[int64])
+	if !ok || len(sum.DataPoints) != 1 || sum.DataPoints[0].Value != 1 {
+		t.Fatalf("errors = %#v", sum)
+	}
+
+	if v, _ := sum.DataPoints[0].Attributes.Value(atomicotel.ErrorTypeKey); v.AsString() != "404" {
+		t.Errorf("error.type = %q", v.AsString())
+	}
+}
+
+func TestOperationTemplate(t *testing.T) {
+	op, ok := atomic.LookupOperation(http.MethodPut, "/api/1.0.0/assets/uploads/u1/parts/3")
+	if !ok {
+		t.Fatal("no operation")
+	}
+
+	if got := op.Template(); got != "/api/1.0.0/assets/uploads/{upload_id}/parts/{part_id}" {
+		t.Fatalf("template = %q", got)
+	}
+}
@@ -0,0 +1,686 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomictest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/libatomic/atomic-go"
+)
+
+type (
+	// Backend is a stateful in-memory atomic.Backend. Resources are kept as
+	// JSON objects per resource name ("User", "Article", ...), so anything
+	// the client sends is stored and returned as is.
+	Backend struct {
+		mu       sync.Mutex
+		objects  map[string]*collection
+		calls    []Call
+		handlers map[string]HandlerFunc
+		uploads  *UploadServer
+	}
+
+	// Call is a request received by the Backend.
+	Call struct {
+		Operation atomic.Operation
+		Method    string
+		Path      string
+		Instance  string
+		// Params is the JSON form of the method params
+		Params json.RawMessage
+	}
+
+	// Store serves the resource operations of a Server; Backend is the
+	// default. Exec results are encoded after it returns, so they must not
+	// share state the store goes on to modify.
+	Store interface {
+		Exec(ctx context.Context, call Call) (any, error)
+	}
+
+	// HandlerFunc replaces the built in behavior of an operation; the result
+	// is encoded to JSON and decoded into the caller's response.
+	HandlerFunc func(ctx context.Context, call Call) (any, error)
+
+	collection struct {
+		order []string
+		items map[string]map[string]any
+	}
+)
+
+var (
+	actions = []string{
+		"Create", "Get", "Update", "Delete", "Remove", "List", "Revoke",
+		"Restart", "Cancel", "Subscribe", "Import", "Export", "Accept",
+	}
+
+	// query keys that never filter a list
+	listKeys = map[string]bool{
+		"limit": true, "offset": true, "expand": true, "fields": true, "instance": true,
+	}
+)
+
+func NewBackend() *Backend {
+	b := &Backend{
+		objects:  make(map[string]*collection),
+		handlers: make(map[string]HandlerFunc),
+	}
+
+	b.uploads = b.newUploads()
+
+	return b
+}
+
+// NewClient returns a client backed by a new Backend.
+func NewClient() (*atomic.Client, *Backend) {
+	b := NewBackend()
+	return atomic.NewClient(b), b
+}
+
+// Handle overrides an operation by name, e.g. "UserGet".
+func (b *Backend) Handle(operation string, fn HandlerFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers[operation] = fn
+}
+
+// Seed stores objects under resource; each is encoded to a JSON object and
+// gets a generated id unless it has one. Ids must be unique.
+func (b *Backend) Seed(resource string, objects ...any) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, v := range objects {
+		obj, err := toObject(v)
+		if err != nil {
+			return err
+		}
+
+		if _, err := b.insert(resource, obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Lookup decodes the stored object into dest, reporting whether it exists.
+func (b *Backend) Lookup(resource, id string, dest any) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	obj, ok := b.collection(resource).items[id]
+	if !ok {
+		return false, nil
+	}
+
+	return true, decode(obj, dest)
+}
+
+// Objects returns copies of the stored objects of a resource in creation
+// order.
+func (b *Backend) Objects(resource string) []map[string]any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.collection(resource)
+
+	rval := make([]map[string]any, 0, len(c.order))
+	for _, id := range c.order {
+		rval = append(rval, cloneObject(c.items[id]))
+	}
+
+	return rval
+}
+
+// Calls returns every call received, oldest first.
+func (b *Backend) Calls() []Call {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]Call(nil), b.calls...)
+}
+
+// CallsTo returns the calls made to an operation.
+func (b *Backend) CallsTo(operation string) []Call {
+	var rval []Call
+
+	for _, c := range b.Calls() {
+		if c.Operation.Name == operation {
+			rval = append(rval, c)
+		}
+	}
+
+	return rval
+}
+
+// Reset drops all objects, calls and handlers.
+func (b *Backend) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.objects = make(map[string]*collection)
+	b.handlers = make(map[string]HandlerFunc)
+	b.calls = nil
+	b.uploads = b.newUploads()
+}
+
+// Uploads returns the server handling resumable asset uploads; completed
+// uploads are stored as Asset objects.
+func (b *Backend) Uploads() *UploadServer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.uploads
+}
+
+func (b *Backend) newUploads() *UploadServer {
+	s := NewUploadServer()
+
+	s.OnComplete = func(asset map[string]any) error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		_, err := b.insert("Asset", cloneObject(asset))
+		return err
+	}
+
+	return s
+}
+
+func (b *Backend) ExecContext(ctx context.Context, params atomic.RequestContainer, result atomic.Responder) error {
+	op, ok := atomic.OperationFor(params)
+	if !ok {
+		return apiError(http.StatusNotFound, "not_found", fmt.Sprintf("no operation for %s %s", params.Method(), params.Path()))
+	}
+
+	call := Call{
+		Operation: op,
+		Method:    params.Method(),
+		Path:      params.Path(),
+		Params:    paramsJSON(params),
+	}
+
+	if i := params.RequestParams().Instance; i != nil {
+		call.Instance = *i
+	}
+
+	if err := atomic.ValidateParams(params); err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(op.Name, "AssetUpload") {
+		b.record(call)
+		return b.upload(ctx, params, result)
+	}
+
+	v, err := b.Exec(ctx, call)
+	if err != nil {
+		return err
+	}
+
+	return respond(v, result)
+}
+
+// Exec records call and runs it against the stored resources, or the handler
+// registered for its operation. Stored objects are copied before they are
+// returned, so the result is safe to use after the lock is released.
+func (b *Backend) Exec(ctx context.Context, call Call) (any, error) {
+	b.mu.Lock()
+	handler := b.handlers[call.Operation.Name]
+	b.mu.Unlock()
+
+	b.record(call)
+
+	if handler != nil {
+		return handler(ctx, call)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	v, err := b.exec(call)
+
+	return clone(v), err
+}
+
+func (b *Backend) record(call Call) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.calls = append(b.calls, call)
+}
+
+// exec runs the built in behavior of an operation, which is derived from its
+// name: the resource followed by the action.
+func (b *Backend) exec(call Call) (any, error) {
+	resource, action := splitOperation(call.Operation.Name)
+	pathParams := atomic.PathParams(call.Operation.Path, call.Path)
+
+	var id string
+	if len(pathParams) > 0 {
+		id = pathParams[len(pathParams)-1]
+	}
+
+	input := make(map[string]any)
+	json.Unmarshal(call.Params, &input)
+
+	switch action {
+	case "Create":
+		obj := withoutNulls(input)
+
+		// nested creates like /users/%s/tokens belong to their parent
+		if len(pathParams) > 0 {
+			obj[parentKey(call.Operation.Path)] = id
+		}
+
+		if resource == "AccessToken" {
+			if _, ok := obj["token"]; !ok {
+				obj["token"] = rand.Text()
+			}
+		}
+
+		return b.insert(resource, obj)
+
+	case "Get":
+		return b.get(resource, id)
+
+	case "Update":
+		obj, err := b.get(resource, id)
+		if err != nil && resource != "Option" {
+			return nil, err
+		}
+
+		if obj == nil {
+			// options are upserted by name
+			obj = map[string]any{"id": id, "name": id}
+			if _, err := b.insert(resource, obj); err != nil {
+				return nil, err
+			}
+		}
+
+		for k, v := range withoutNulls(input) {
+			if k != "id" {
+				obj[k] = v
+			}
+		}
+
+		return obj, nil
+
+	case "Delete", "Remove", "Revoke":
+		obj, err := b.get(resource, id)
+		if err != nil {
+			return nil, err
+		}
+
+		b.remove(resource, id)
+
+		return obj, nil
+
+	case "List":
+		return b.list(resource, call.Path, input), nil
+
+	case "Restart", "Cancel":
+		obj, err := b.get(resource, id)
+		if err != nil {
+			return nil, err
+		}
+
+		obj["status"] = map[string]string{"Restart": "pending", "Cancel": "canceled"}[action]
+
+		return obj, nil
+
+	case "Subscribe":
+		if _, err := b.get(resource, id); err != nil {
+			return nil, err
+		}
+
+		obj := withoutNulls(input)
+		obj["plan_id"] = id
+
+		return b.insert("Subscription", obj)
+
+	case "Import", "Export":
+		return b.insert("Job", map[string]any{
+			"type":   strings.ToLower(resource + "_" + action),
+			"status": "pending",
+		})
+
+	case "Accept":
+		obj, err := b.get(resource, id)
+		if err != nil {
+			return nil, err
+		}
+
+		obj["accepted"] = true
+
+		return obj, nil
+
+	case "Send":
+		return map[string]any{}, nil
+	}
+
+	return nil, apiError(http.StatusNotImplemented, "not_implemented", call.Operation.Name+" is not supported")
+}
+
+func (b *Backend) collection(resource string) *collection {
+	c, ok := b.objects[resource]
+	if !ok {
+		c = &collection{items: make(map[string]map[string]any)}
+		b.objects[resource] = c
+	}
+
+	return c
+}
+
+func (b *Backend) insert(resource string, obj map[string]any) (map[string]any, error) {
+	c := b.collection(resource)
+
+	id, _ := obj["id"].(string)
+	if id == "" {
+		id = newID()
+		obj["id"] = id
+	}
+
+	if _, ok := c.items[id]; ok {
+		return nil, apiError(http.StatusConflict, "conflict", fmt.Sprintf("%s %s already exists", resource, id))
+	}
+
+	c.items[id] = obj
+	c.order = append(c.order, id)
+
+	return obj, nil
+}
+
+func (b *Backend) get(resource, id string) (map[string]any, error) {
+	obj, ok := b.collection(resource).items[id]
+	if !ok {
+		return nil, apiError(http.StatusNotFound, "not_found", fmt.Sprintf("%s %s not found", resource, id))
+	}
+
+	return obj, nil
+}
+
+func (b *Backend) remove(resource, id string) {
+	c := b.collection(resource)
+
+	delete(c.items, id)
+
+	for i, v := range c.order {
+		if v == id {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// list pages through a collection with the limit and offset of the query,
+// keeping objects whose fields equal the scalar filters of the input.
+func (b *Backend) list(resource, path string, input map[string]any) []map[string]any {
+	var query url.Values
+	if _, q, ok := strings.Cut(path, "?"); ok {
+		query, _ = url.ParseQuery(q)
+	}
+
+	c := b.collection(resource)
+
+	rval := make([]map[string]any, 0)
+
+	for _, id := range c.order {
+		obj := c.items[id]
+		if matches(obj, input) {
+			rval = append(rval, obj)
+		}
+	}
+
+	offset := min(queryInt(query, input, "offset"), len(rval))
+	rval = rval[offset:]
+
+	if limit := queryInt(query, input, "limit"); limit > 0 && limit < len(rval) {
+		rval = rval[:limit]
+	}
+
+	return rval
+}
+
+// upload hands the chunked upload protocol to an in-process UploadServer.
+func (b *Backend) upload(ctx context.Context, params atomic.RequestContainer, result atomic.Responder) error {
+	req := httptest.NewRequestWithContext(ctx, params.Method(), params.Path(), params.Body())
+	req.Header.Set("Content-Type", params.ContentType())
+
+	for k, v := range params.RequestParams().Headers {
+		req.Header[k] = v
+	}
+
+	rec := httptest.NewRecorder()
+	b.Uploads().ServeHTTP(rec, req)
+
+	if rec.Code >= 400 {
+		e := atomic.Error{
+			Status:     fmt.Sprintf("%d %s", rec.Code, http.StatusText(rec.Code)),
+			StatusCode: rec.Code,
+		}
+		json.Unmarshal(rec.Body.Bytes(), &e)
+		return e
+	}
+
+	return respondJSON(rec.Code, rec.Body.Bytes(), result)
+}
+
+func splitOperation(name string) (string, string) {
+	if resource, ok := strings.CutPrefix(name, "Send"); ok {
+		return resource, "Send"
+	}
+
+	for _, a := range actions {
+		if resource, ok := strings.CutSuffix(name, a); ok && resource != "" {
+			return resource, a
+		}
+	}
+
+	return name, ""
+}
+
+// parentKey names the field holding the parent id of a nested create, e.g.
+// user_id for /users/%s/tokens.
+func parentKey(template string) string {
+	segs := strings.Split(strings.Trim(template, "/"), "/")
+
+	for i := len(segs) - 1; i > 0; i-- {
+		if segs[i] == "%s" {
+			return strings.TrimSuffix(segs[i-1], "s") + "_id"
+		}
+	}
+
+	return "parent_id"
+}
+
+func matches(obj, filter map[string]any) bool {
+	for k, want := range filter {
+		if listKeys[k] {
+			continue
+		}
+
+		switch want.(type) {
+		case string, float64, bool:
+		default:
+			continue
+		}
+
+		if have, ok := obj[k]; ok && have != want {
+			return false
+		}
+	}
+
+	return true
+}
+
+func queryInt(query url.Values, input map[string]any, key string) int {
+	if v := query.Get(key); v != "" {
+		n, _ := strconv.Atoi(v)
+		return max(n, 0)
+	}
+
+	if n, ok := input[key].(float64); ok {
+		return max(int(n), 0)
+	}
+
+	return 0
+}
+
+func paramsJSON(params atomic.RequestContainer) json.RawMessage {
+	data, err := json.Marshal(params.MethodParams())
+	if err != nil {
+		// inputs carrying readers can't always be encoded
+		return json.RawMessage("{}")
+	}
+
+	return data
+}
+
+func withoutNulls(m map[string]any) map[string]any {
+	rval := make(map[string]any, len(m))
+
+	for k, v := range m {
+		if v != nil {
+			rval[k] = v
+		}
+	}
+
+	return rval
+}
+
+func toObject(v any) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj map[string]any
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("fixtures must encode to JSON objects: %w", err)
+	}
+
+	return obj, nil
+}
+
+// clone deep copies the maps and slices of a JSON value.
+func clone(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		return cloneObject(v)
+
+	case []map[string]any:
+		rval := make([]map[string]any, len(v))
+		for i, obj := range v {
+			rval[i] = cloneObject(obj)
+		}
+		return rval
+
+	case []any:
+		rval := make([]any, len(v))
+		for i, x := range v {
+			rval[i] = clone(x)
+		}
+		return rval
+	}
+
+	return v
+}
+
+func cloneObject(obj map[string]any) map[string]any {
+	if obj == nil {
+		return nil
+	}
+
+	rval := make(map[string]any, len(obj))
+	for k, v := range obj {
+		rval[k] = clone(v)
+	}
+
+	return rval
+}
+
+func decode(v any, dest any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dest)
+}
+
+func respond(v any, result atomic.Responder) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return respondJSON(http.StatusOK, data, result)
+}
+
+func respondJSON(status int, body []byte, result atomic.Responder) error {
+	if result == nil {
+		return nil
+	}
+
+	result.SetLastResponse(&atomic.Response{
+		Headers:    http.Header{"Content-Type": {"application/json"}},
+		Body:       body,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		RequestID:  newID(),
+		Attempts:   1,
+	})
+
+	return json.NewDecoder(bytes.NewReader(body)).Decode(result.Response())
+}
+
+func apiError(status int, code, msg string) error {
+	return atomic.Error{
+		Code:       code,
+		Message:    msg,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+	}
+}
+
+func newID() string {
+	var b [16]byte
+	rand.Read(b[:])
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+var (
+	_ atomic.Backend = (*Backend)(nil)
+	_ Store          = (*Backend)(nil)
+)
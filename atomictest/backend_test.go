@@ -0,0 +1,111 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomictest_test
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/libatomic/atomic-go"
+	"github.com/libatomic/atomic-go/atomictest"
+	types "github.com/libatomic/atomic/pkg/atomic"
+)
+
+const testUserID = "0b0f5d6c-4a8e-4c1e-9b51-7f3d2d1f6a01"
+
+func testID(t testing.TB, s string) *types.ID {
+	t.Helper()
+
+	var id types.ID
+	if err := json.Unmarshal([]byte(strconv.Quote(s)), &id); err != nil {
+		t.Fatal(err)
+	}
+
+	return &id
+}
+
+func TestBackendReturnsCopies(t *testing.T) {
+	_, b := atomictest.NewClient()
+
+	if err := b.Seed("User", map[string]any{"id": testUserID, "tags": []any{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	objs := b.Objects("User")
+	objs[0]["name"] = "changed"
+	objs[0]["tags"].([]any)[0] = "changed"
+
+	call := atomictest.Call{
+		Operation: atomic.Operation{Name: "UserGet", Path: atomic.UserGetPath},
+		Path:      "/api/1.0.0/users/" + testUserID,
+	}
+	v, err := b.Exec(context.Background(), call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.(map[string]any)["tags"].([]any)[0] = "changed"
+
+	var stored map[string]any
+	if ok, err := b.Lookup("User", testUserID, &stored); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if _, ok := stored["name"]; ok || stored["tags"].([]any)[0] != "a" {
+		t.Errorf("stored object was modified through a returned copy: %v", stored)
+	}
+}
+
+// run with -race: responses are encoded after the backend lock is released
+func TestBackendConcurrentGetAndUpdate(t *testing.T) {
+	client, b := atomictest.NewClient()
+
+	if err := b.Seed("User", map[string]any{"id": testUserID}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+
+	for range 4 {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			for range 500 {
+				if _, err := client.UserGet(ctx, &atomic.UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for range 500 {
+				if _, err := client.UserUpdate(ctx, &atomic.UserUpdateInput{UserID: testID(t, testUserID)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
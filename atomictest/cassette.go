@@ -0,0 +1,534 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomictest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/libatomic/atomic-go"
+)
+
+type (
+	// Cassette is the file format of recorded traffic.
+	Cassette struct {
+		Version      int            `json:"version"`
+		Interactions []*Interaction `json:"interactions"`
+	}
+
+	// Interaction is a recorded call and its outcome.
+	Interaction struct {
+		Operation string `json:"operation"`
+		Method    string `json:"method"`
+		Path      string `json:"path"`
+		Instance  string `json:"instance,omitempty"`
+		// Request is the JSON form of the method params
+		Request  json.RawMessage  `json:"request,omitempty"`
+		Response RecordedResponse `json:"response"`
+		template string
+	}
+
+	// RecordedResponse is the response of an Interaction; Error holds calls
+	// that failed before a response was received.
+	RecordedResponse struct {
+		Status     string          `json:"status,omitempty"`
+		StatusCode int             `json:"status_code,omitempty"`
+		Headers    http.Header     `json:"headers,omitempty"`
+		Body       json.RawMessage `json:"body,omitempty"`
+		Text       string          `json:"text,omitempty"`
+		Error      string          `json:"error,omitempty"`
+	}
+
+	// Recorder is an atomic.Backend that passes calls to another backend and
+	// records them; Save writes the cassette.
+	Recorder struct {
+		next     atomic.Backend
+		path     string
+		config   cassetteConfig
+		mu       sync.Mutex
+		cassette Cassette
+	}
+
+	// Replayer is an atomic.Backend answering calls from a cassette. Each
+	// interaction is used once, in recorded order among its matches.
+	Replayer struct {
+		config    cassetteConfig
+		mu        sync.Mutex
+		cassette  *Cassette
+		used      []bool
+		unmatched []string
+	}
+
+	CassetteOption func(*cassetteConfig)
+
+	cassetteConfig struct {
+		redact    map[string]bool
+		scrubbers []func(*Interaction)
+	}
+
+	// recording captures the response of a call for a Recorder.
+	recording struct {
+		result atomic.Responder
+		last   *atomic.Response
+	}
+)
+
+const (
+	// CassetteVersion is the cassette format written by Recorder.
+	CassetteVersion = 1
+
+	// Redacted replaces the values of redacted fields.
+	Redacted = "REDACTED"
+)
+
+var (
+	ErrUnmatchedInteraction = errors.New("no recorded interaction matches")
+	ErrUnusedInteraction    = errors.New("recorded interaction was not used")
+
+	// DefaultRedactedFields are the JSON fields always redacted from
+	// cassettes.
+	DefaultRedactedFields = []string{
+		"token", "access_token", "refresh_token", "id_token", "client_secret", "secret", "password",
+	}
+
+	redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+)
+
+// WithRedactedFields redacts JSON fields and query parameters with these
+// names, at any depth, in addition to DefaultRedactedFields.
+func WithRedactedFields(names ...string) CassetteOption {
+	return func(c *cassetteConfig) {
+		for _, n := range names {
+			c.redact[n] = true
+		}
+	}
+}
+
+// WithScrubber rewrites interactions before they are recorded, e.g. to mask
+// timestamps. Replayer applies it to live calls before matching, so both
+// sides compare equal.
+func WithScrubber(fn func(*Interaction)) CassetteOption {
+	return func(c *cassetteConfig) {
+		c.scrubbers = append(c.scrubbers, fn)
+	}
+}
+
+func newCassetteConfig(opts []CassetteOption) cassetteConfig {
+	c := cassetteConfig{redact: make(map[string]bool)}
+
+	for _, n := range DefaultRedactedFields {
+		c.redact[n] = true
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return c
+}
+
+// LoadCassette reads a cassette file.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cassette %s: %w", path, err)
+	}
+
+	if c.Version != CassetteVersion {
+		return nil, fmt.Errorf("cassette %s: unsupported version %d", path, c.Version)
+	}
+
+	for _, i := range c.Interactions {
+		if op, ok := atomic.LookupOperation(i.Method, i.Path); ok {
+			i.template = op.Path
+		}
+	}
+
+	return &c, nil
+}
+
+// NewRecorder records the calls made through next to the cassette at path.
+func NewRecorder(next atomic.Backend, path string, opts ...CassetteOption) *Recorder {
+	return &Recorder{
+		next:     next,
+		path:     path,
+		config:   newCassetteConfig(opts),
+		cassette: Cassette{Version: CassetteVersion},
+	}
+}
+
+func (r *Recorder) ExecContext(ctx context.Context, params atomic.RequestContainer, result atomic.Responder) error {
+	rec := &recording{result: result}
+
+	err := r.next.ExecContext(ctx, params, rec)
+
+	i := r.config.interaction(params)
+
+	switch {
+	case rec.last != nil:
+		i.Response = recordedResponse(rec.last)
+
+	case err != nil:
+		var e atomic.Error
+		if !errors.As(err, &e) {
+			i.Response.Error = err.Error()
+			break
+		}
+
+		// backends that don't speak HTTP only return the Error
+		body, _ := json.Marshal(e)
+		i.Response = RecordedResponse{
+			Status:     e.Status,
+			StatusCode: e.StatusCode,
+			Body:       body,
+		}
+	}
+
+	r.config.scrub(i)
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, i)
+	r.mu.Unlock()
+
+	return err
+}
+
+// Save writes the interactions recorded so far.
+func (r *Recorder) Save() error {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	r.mu.Lock()
+	err := enc.Encode(r.cassette)
+	r.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, r.path)
+}
+
+// NewReplayer answers calls from the cassette at path; pass the options used
+// to record it.
+func NewReplayer(path string, opts ...CassetteOption) (*Replayer, error) {
+	c, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Replayer{
+		config:   newCassetteConfig(opts),
+		cassette: c,
+		used:     make([]bool, len(c.Interactions)),
+	}, nil
+}
+
+// ExecContext replays the first unused interaction with the same method,
+// path template, query and normalized JSON params.
+func (r *Replayer) ExecContext(ctx context.Context, params atomic.RequestContainer, result atomic.Responder) error {
+	live := r.config.interaction(params)
+	r.config.scrub(live)
+
+	rec, err := r.match(live)
+	if err != nil {
+		return err
+	}
+
+	resp := rec.Response
+
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+
+	body := []byte(resp.Body)
+	if resp.Text != "" {
+		body = []byte(resp.Text)
+	}
+
+	last := &atomic.Response{
+		Headers:    resp.Headers,
+		Body:       body,
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Headers.Get(atomic.RequestIDHeader),
+		Attempts:   1,
+	}
+
+	if result != nil {
+		result.SetLastResponse(last)
+	}
+
+	if resp.StatusCode >= 400 {
+		e := atomic.Error{
+			Status:     resp.Status,
+			StatusCode: resp.StatusCode,
+			RequestID:  last.RequestID,
+		}
+
+		if err := json.Unmarshal(body, &e); err != nil || (e.Code == "" && e.Message == "") {
+			e.Raw = string(body)
+		}
+
+		return e
+	}
+
+	if len(body) > 0 && result != nil {
+		return json.NewDecoder(bytes.NewReader(body)).Decode(result.Response())
+	}
+
+	return nil
+}
+
+// Verify reports the calls that matched nothing and the interactions that
+// were never replayed.
+func (r *Replayer) Verify() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+
+	for _, u := range r.unmatched {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrUnmatchedInteraction, u))
+	}
+
+	for n, i := range r.cassette.Interactions {
+		if !r.used[n] {
+			errs = append(errs, fmt.Errorf("%w: #%d %s %s", ErrUnusedInteraction, n, i.Method, i.Path))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *Replayer) match(live *Interaction) (*Interaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for n, i := range r.cassette.Interactions {
+		if r.used[n] || !matchInteraction(i, live) {
+			continue
+		}
+
+		r.used[n] = true
+
+		return i, nil
+	}
+
+	desc := fmt.Sprintf("%s %s %s", live.Method, live.Path, live.Request)
+	r.unmatched = append(r.unmatched, desc)
+
+	return nil, fmt.Errorf("%w: %s", ErrUnmatchedInteraction, desc)
+}
+
+func matchInteraction(rec, live *Interaction) bool {
+	if rec.Method != live.Method || rec.template != live.template || rec.template == "" {
+		return false
+	}
+
+	if normalizeQuery(rec.Path) != normalizeQuery(live.Path) {
+		return false
+	}
+
+	return bytes.Equal(normalizeJSON(rec.Request), normalizeJSON(live.Request))
+}
+
+// interaction describes the request of a call, redacted.
+func (c cassetteConfig) interaction(params atomic.RequestContainer) *Interaction {
+	i := &Interaction{
+		Method:  params.Method(),
+		Path:    c.redactPath(params.Path()),
+		Request: c.redactJSON(paramsJSON(params)),
+	}
+
+	if op, ok := atomic.OperationFor(params); ok {
+		i.Operation, i.template = op.Name, op.Path
+	}
+
+	if v := params.RequestParams().Instance; v != nil {
+		i.Instance = *v
+	}
+
+	return i
+}
+
+func (c cassetteConfig) scrub(i *Interaction) {
+	for _, h := range redactedHeaders {
+		if i.Response.Headers.Get(h) != "" {
+			i.Response.Headers.Set(h, Redacted)
+		}
+	}
+
+	i.Response.Body = c.redactJSON(i.Response.Body)
+
+	for _, fn := range c.scrubbers {
+		fn(i)
+	}
+}
+
+// redactPath redacts the values of query parameters named like redacted
+// fields.
+func (c cassetteConfig) redactPath(path string) string {
+	u, err := url.Parse(path)
+	if err != nil || u.RawQuery == "" {
+		return path
+	}
+
+	q := u.Query()
+	redacted := false
+
+	for k, vals := range q {
+		if !c.redact[k] {
+			continue
+		}
+
+		for n := range vals {
+			vals[n] = Redacted
+		}
+		redacted = true
+	}
+
+	if !redacted {
+		return path
+	}
+
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+func (c cassetteConfig) redactJSON(data json.RawMessage) json.RawMessage {
+	if len(data) == 0 {
+		return data
+	}
+
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return data
+	}
+
+	rval, err := json.Marshal(c.redactValue(v))
+	if err != nil {
+		return data
+	}
+
+	return rval
+}
+
+func (c cassetteConfig) redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if c.redact[k] && val != nil {
+				v[k] = Redacted
+			} else {
+				v[k] = c.redactValue(val)
+			}
+		}
+
+	case []any:
+		for n, val := range v {
+			v[n] = c.redactValue(val)
+		}
+	}
+
+	return v
+}
+
+func recordedResponse(resp *atomic.Response) RecordedResponse {
+	rval := RecordedResponse{
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Headers.Clone(),
+	}
+
+	if json.Valid(resp.Body) {
+		rval.Body = bytes.Clone(resp.Body)
+	} else {
+		rval.Text = string(resp.Body)
+	}
+
+	return rval
+}
+
+func normalizeQuery(path string) string {
+	u, err := url.Parse(path)
+	if err != nil {
+		return path
+	}
+
+	return u.Query().Encode()
+}
+
+func normalizeJSON(data json.RawMessage) []byte {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return data
+	}
+
+	rval, _ := json.Marshal(v)
+
+	return rval
+}
+
+func (r *recording) SetLastResponse(resp *atomic.Response) {
+	r.last = resp
+
+	if r.result != nil {
+		r.result.SetLastResponse(resp)
+	}
+}
+
+// Response discards the body of calls that expect no result.
+func (r *recording) Response() any {
+	if r.result != nil {
+		return r.result.Response()
+	}
+
+	return new(json.RawMessage)
+}
+
+var (
+	_ atomic.Backend = (*Recorder)(nil)
+	_ atomic.Backend = (*Replayer)(nil)
+)
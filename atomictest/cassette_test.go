@@ -0,0 +1,128 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomictest_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libatomic/atomic-go"
+	"github.com/libatomic/atomic-go/atomictest"
+)
+
+// recordUsers runs the calls both recorded and replayed by the cassette tests
+func recordUsers(t *testing.T, client *atomic.Client) error {
+	t.Helper()
+
+	ctx := atomic.ContextWithParams(context.Background(), atomic.Params{
+		Query: url.Values{"token": {"query-secret"}, "email": {"a@example.com"}, "sort": {"name"}},
+	})
+
+	if _, err := client.AccessTokenCreate(ctx, &atomic.AccessTokenCreateInput{UserID: testID(t, testUserID)}); err != nil {
+		return err
+	}
+
+	if _, err := client.UserGet(ctx, &atomic.UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func newUserBackend(t *testing.T) *atomictest.Backend {
+	t.Helper()
+
+	b := atomictest.NewBackend()
+	if err := b.Seed("User", map[string]any{"id": testUserID}); err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestCassetteRedactsAndReplays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassettes", "users.json")
+
+	rec := atomictest.NewRecorder(newUserBackend(t), path, atomictest.WithRedactedFields("email"))
+	if err := recordUsers(t, atomic.NewClient(rec)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"query-secret", "a@example.com", "a%40example.com"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains %q:\n%s", secret, data)
+		}
+	}
+	if !strings.Contains(string(data), "sort=name") || !strings.Contains(string(data), "token=REDACTED") {
+		t.Errorf("cassette query not redacted as expected:\n%s", data)
+	}
+	if !strings.Contains(string(data), `"token": "REDACTED"`) {
+		t.Errorf("token body field not redacted:\n%s", data)
+	}
+
+	rp, err := atomictest.NewReplayer(path, atomictest.WithRedactedFields("email"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recordUsers(t, atomic.NewClient(rp)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayerReportsUnmatchedAndUnused(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+
+	rec := atomictest.NewRecorder(newUserBackend(t), path)
+	if err := recordUsers(t, atomic.NewClient(rec)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	rp, err := atomictest.NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := atomic.NewClient(rp)
+	if _, err := client.UserGet(context.Background(), &atomic.UserGetInput{UserID: testID(t, testUserID)}); !errors.Is(err, atomictest.ErrUnmatchedInteraction) {
+		t.Fatalf("err = %v, want ErrUnmatchedInteraction", err)
+	}
+
+	err = rp.Verify()
+	if !errors.Is(err, atomictest.ErrUnmatchedInteraction) || !errors.Is(err, atomictest.ErrUnusedInteraction) {
+		t.Errorf("Verify = %v", err)
+	}
+}
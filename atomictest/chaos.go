@@ -0,0 +1,395 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomictest
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/libatomic/atomic-go"
+)
+
+type (
+	// Chaos injects faults into calls matching its rules. Rolls come from a
+	// seeded source, so the same seed and call sequence give the same faults.
+	// Wrap a Backend with Backend, or the HTTP transport with Transport to
+	// also exercise the client's retries.
+	Chaos struct {
+		mu       sync.Mutex
+		rng      *rand.Rand
+		rules    []ChaosRule
+		injected []Injection
+	}
+
+	// ChaosRule injects Fault into calls matching Method, Path and Instance;
+	// empty fields match anything.
+	ChaosRule struct {
+		Method string
+		// Path is a path.Match pattern for the request path, without query,
+		// e.g. /api/1.0.0/users/*
+		Path     string
+		Instance string
+		// Probability is the chance a matching call is affected; Always
+		// affects every matching call
+		Probability float64
+		Always      bool
+		Fault       ChaosFault
+		// Latency and up to Jitter more are added by ChaosLatency
+		Latency time.Duration
+		Jitter  time.Duration
+		// Status is the HTTP status returned by ChaosStatus, 503 if zero
+		Status int
+	}
+
+	// ChaosFault is the kind of fault a rule injects.
+	ChaosFault int
+
+	// Injection is a fault that was injected.
+	Injection struct {
+		Rule     int
+		Fault    ChaosFault
+		Method   string
+		Path     string
+		Instance string
+	}
+
+	chaosBackend struct {
+		chaos *Chaos
+		next  atomic.Backend
+	}
+
+	chaosTransport struct {
+		chaos *Chaos
+		next  http.RoundTripper
+	}
+
+	// truncatedReader fails with io.ErrUnexpectedEOF after its data, like a
+	// connection dropped mid-response.
+	truncatedReader struct {
+		r io.Reader
+	}
+)
+
+const (
+	// ChaosLatency delays the call; unlike the other faults it combines
+	// with any other matching rule.
+	ChaosLatency ChaosFault = iota + 1
+
+	// ChaosStatus fails the call with an HTTP status error.
+	ChaosStatus
+
+	// ChaosReset fails the call with a connection reset.
+	ChaosReset
+
+	// ChaosTruncate cuts the response body in half.
+	ChaosTruncate
+
+	// ChaosMalformed replaces the response body with an HTML error page, as
+	// a misbehaving proxy would.
+	ChaosMalformed
+)
+
+const (
+	malformedBody = "<html><body><h1>502 Bad Gateway</h1></body></html>"
+)
+
+var (
+	// ErrFaultNotApplied is returned by Chaos.Backend when a truncate or
+	// malformed fault fires on a call without a response body to alter.
+	ErrFaultNotApplied = errors.New("chaos fault could not be applied")
+)
+
+func NewChaos(seed uint64, rules ...ChaosRule) *Chaos {
+	return &Chaos{
+		rng:   rand.New(rand.NewPCG(seed, seed)),
+		rules: rules,
+	}
+}
+
+// Backend wraps next; status and reset faults skip it, truncated and
+// malformed bodies are applied to its response. Calls next answers without a
+// response, or that expect no result, fail with ErrFaultNotApplied instead.
+func (c *Chaos) Backend(next atomic.Backend) atomic.Backend {
+	return &chaosBackend{chaos: c, next: next}
+}
+
+// Transport wraps next, http.DefaultTransport if nil, for use with
+// atomic.WithHTTPClient.
+func (c *Chaos) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &chaosTransport{chaos: c, next: next}
+}
+
+// Injected returns the faults injected so far, oldest first.
+func (c *Chaos) Injected() []Injection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Injection(nil), c.injected...)
+}
+
+// roll evaluates the rules for a call, returning the total latency and the
+// first failure to inject, if any.
+func (c *Chaos) roll(method, p, instance string) (time.Duration, *ChaosRule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var delay time.Duration
+
+	for n := range c.rules {
+		r := &c.rules[n]
+
+		if !r.matches(method, p, instance) {
+			continue
+		}
+
+		if !r.Always && c.rng.Float64() >= r.Probability {
+			continue
+		}
+
+		c.injected = append(c.injected, Injection{
+			Rule:     n,
+			Fault:    r.Fault,
+			Method:   method,
+			Path:     p,
+			Instance: instance,
+		})
+
+		if r.Fault != ChaosLatency {
+			return delay, r
+		}
+
+		delay += r.Latency
+		if r.Jitter > 0 {
+			delay += time.Duration(c.rng.Int64N(int64(r.Jitter) + 1))
+		}
+	}
+
+	return delay, nil
+}
+
+func (r *ChaosRule) matches(method, p, instance string) bool {
+	if r.Method != "" && !strings.EqualFold(r.Method, method) {
+		return false
+	}
+
+	if r.Instance != "" && r.Instance != instance {
+		return false
+	}
+
+	if r.Path != "" {
+		if ok, _ := path.Match(r.Path, p); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (b *chaosBackend) ExecContext(ctx context.Context, params atomic.RequestContainer, result atomic.Responder) error {
+	p, _, _ := strings.Cut(params.Path(), "?")
+
+	var instance string
+	if v := params.RequestParams().Instance; v != nil {
+		instance = *v
+	}
+
+	delay, rule := b.chaos.roll(params.Method(), p, instance)
+
+	if err := wait(ctx, delay); err != nil {
+		return err
+	}
+
+	if rule == nil {
+		return b.next.ExecContext(ctx, params, result)
+	}
+
+	switch rule.Fault {
+	case ChaosStatus:
+		resp, e := statusResponse(rule.Status)
+		if result != nil {
+			result.SetLastResponse(resp)
+		}
+		return e
+
+	case ChaosReset:
+		return resetError()
+	}
+
+	rec := &recording{}
+	if err := b.next.ExecContext(ctx, params, rec); err != nil {
+		return err
+	}
+
+	if rec.last == nil || result == nil {
+		return fmt.Errorf("%w: %s on %s %s", ErrFaultNotApplied, rule.Fault, params.Method(), p)
+	}
+
+	resp := *rec.last
+	resp.Body = mangle(rule.Fault, resp.Body)
+
+	result.SetLastResponse(&resp)
+
+	return json.NewDecoder(bytes.NewReader(resp.Body)).Decode(result.Response())
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay, rule := t.chaos.roll(req.Method, req.URL.Path, req.Header.Get("Atomic-Instance"))
+
+	if err := wait(req.Context(), delay); err != nil {
+		return nil, err
+	}
+
+	if rule == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	switch rule.Fault {
+	case ChaosStatus:
+		if req.Body != nil {
+			req.Body.Close()
+		}
+
+		r, _ := statusResponse(rule.Status)
+
+		return &http.Response{
+			Status:        r.Status,
+			StatusCode:    r.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        r.Headers,
+			Body:          io.NopCloser(bytes.NewReader(r.Body)),
+			ContentLength: int64(len(r.Body)),
+			Request:       req,
+		}, nil
+
+	case ChaosReset:
+		if req.Body != nil {
+			req.Body.Close()
+		}
+
+		return nil, resetError()
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	body = mangle(rule.Fault, body)
+
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+
+	if rule.Fault == ChaosTruncate {
+		resp.Body = io.NopCloser(&truncatedReader{r: bytes.NewReader(body)})
+	} else {
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	return resp, nil
+}
+
+func (r *truncatedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+func (f ChaosFault) String() string {
+	switch f {
+	case ChaosLatency:
+		return "latency"
+	case ChaosStatus:
+		return "status"
+	case ChaosReset:
+		return "reset"
+	case ChaosTruncate:
+		return "truncate"
+	case ChaosMalformed:
+		return "malformed"
+	}
+
+	return "ChaosFault(" + strconv.Itoa(int(f)) + ")"
+}
+
+func mangle(fault ChaosFault, body []byte) []byte {
+	if fault == ChaosMalformed {
+		return []byte(malformedBody)
+	}
+
+	return body[:len(body)/2]
+}
+
+// statusResponse builds the response and Error of an injected status.
+func statusResponse(status int) (*atomic.Response, error) {
+	status = cmp.Or(status, http.StatusServiceUnavailable)
+
+	e := atomic.Error{
+		Code:       statusCode(status),
+		Message:    "injected fault",
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+	}
+
+	body, _ := json.Marshal(e)
+
+	return &atomic.Response{
+		Headers:    http.Header{"Content-Type": {"application/json"}},
+		Body:       body,
+		Status:     e.Status,
+		StatusCode: status,
+		Attempts:   1,
+	}, e
+}
+
+func resetError() error {
+	return &net.OpError{
+		Op:  "read",
+		Net: "tcp",
+		Err: os.NewSyscallError("read", syscall.ECONNRESET),
+	}
+}
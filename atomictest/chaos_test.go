@@ -0,0 +1,170 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomictest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/libatomic/atomic-go"
+	"github.com/libatomic/atomic-go/atomictest"
+)
+
+const testTokenID = "3e9d1c7b-5a2f-4b8e-9c6d-0f1a2b3c4d05"
+
+func chaosGet(t *testing.T, chaos *atomictest.Chaos, next atomic.Backend) error {
+	t.Helper()
+
+	client := atomic.NewClient(chaos.Backend(next))
+	_, err := client.UserGet(context.Background(), &atomic.UserGetInput{UserID: testID(t, testUserID)})
+
+	return err
+}
+
+func TestChaosIsReproducible(t *testing.T) {
+	b := newUserBackend(t)
+	rule := atomictest.ChaosRule{Path: "/api/1.0.0/users/*", Probability: 0.5, Fault: atomictest.ChaosStatus}
+
+	run := func() []atomictest.Injection {
+		chaos := atomictest.NewChaos(42, rule)
+
+		failed := 0
+		for range 100 {
+			var e atomic.Error
+			if err := chaosGet(t, chaos, b); errors.As(err, &e) && e.StatusCode == http.StatusServiceUnavailable {
+				failed++
+			}
+		}
+
+		if failed < 30 || failed > 70 || failed != len(chaos.Injected()) {
+			t.Fatalf("%d of 100 failed, %d injected", failed, len(chaos.Injected()))
+		}
+
+		return chaos.Injected()
+	}
+
+	if !reflect.DeepEqual(run(), run()) {
+		t.Error("the same seed injected different faults")
+	}
+}
+
+func TestChaosProbability(t *testing.T) {
+	b := newUserBackend(t)
+
+	never := atomictest.NewChaos(1, atomictest.ChaosRule{Fault: atomictest.ChaosStatus})
+	always := atomictest.NewChaos(1, atomictest.ChaosRule{Always: true, Fault: atomictest.ChaosStatus})
+
+	for range 20 {
+		if err := chaosGet(t, never, b); err != nil {
+			t.Fatalf("a zero probability rule fired: %v", err)
+		}
+		if err := chaosGet(t, always, b); err == nil {
+			t.Fatal("an Always rule did not fire")
+		}
+	}
+
+	if n := len(never.Injected()); n != 0 {
+		t.Errorf("zero probability injected %d faults", n)
+	}
+}
+
+func TestChaosBackendFaults(t *testing.T) {
+	b := newUserBackend(t)
+
+	tests := []struct {
+		fault atomictest.ChaosFault
+		check func(error) bool
+	}{
+		{atomictest.ChaosStatus, func(err error) bool { return errors.Is(err, atomic.ErrRateLimited) }},
+		{atomictest.ChaosReset, func(err error) bool { return errors.Is(err, syscall.ECONNRESET) }},
+		{atomictest.ChaosTruncate, func(err error) bool { return errors.Is(err, io.ErrUnexpectedEOF) }},
+		{atomictest.ChaosMalformed, func(err error) bool {
+			var se *json.SyntaxError
+			return errors.As(err, &se)
+		}},
+	}
+
+	for _, tt := range tests {
+		chaos := atomictest.NewChaos(1, atomictest.ChaosRule{
+			Method: http.MethodGet,
+			Always: true,
+			Fault:  tt.fault,
+			Status: http.StatusTooManyRequests,
+		})
+		if err := chaosGet(t, chaos, b); !tt.check(err) {
+			t.Errorf("%s: err = %v", tt.fault, err)
+		}
+
+		other := atomictest.NewChaos(1, atomictest.ChaosRule{Instance: "other", Always: true, Fault: tt.fault})
+		if err := chaosGet(t, other, b); err != nil {
+			t.Errorf("%s fired for another instance: %v", tt.fault, err)
+		}
+	}
+}
+
+func TestChaosFaultNotApplied(t *testing.T) {
+	ctx := context.Background()
+
+	for _, fault := range []atomictest.ChaosFault{atomictest.ChaosTruncate, atomictest.ChaosMalformed} {
+		chaos := atomictest.NewChaos(1, atomictest.ChaosRule{Always: true, Fault: fault})
+
+		b := atomictest.NewBackend()
+		if err := b.Seed("AccessToken", map[string]any{"id": testTokenID}); err != nil {
+			t.Fatal(err)
+		}
+
+		// the call expects no result
+		client := atomic.NewClient(chaos.Backend(b))
+		err := client.AccessTokenRevoke(ctx, &atomic.AccessTokenRevokeInput{AccessTokenID: testID(t, testTokenID)})
+		if !errors.Is(err, atomictest.ErrFaultNotApplied) {
+			t.Errorf("%s without a result: err = %v", fault, err)
+		}
+
+		// the backend answers without a response
+		silent := atomic.BackendFunc(func(context.Context, atomic.RequestContainer, atomic.Responder) error {
+			return nil
+		})
+		if err := chaosGet(t, chaos, silent); !errors.Is(err, atomictest.ErrFaultNotApplied) {
+			t.Errorf("%s without a response: err = %v", fault, err)
+		}
+	}
+}
+
+func TestChaosLatency(t *testing.T) {
+	chaos := atomictest.NewChaos(1, atomictest.ChaosRule{
+		Always:  true,
+		Fault:   atomictest.ChaosLatency,
+		Latency: 20 * time.Millisecond,
+		Jitter:  10 * time.Millisecond,
+	})
+
+	start := time.Now()
+	if err := chaosGet(t, chaos, newUserBackend(t)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("call took %v, want at least 20ms", d)
+	}
+}
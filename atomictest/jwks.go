@@ -0,0 +1,117 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomictest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+type (
+	// JWKSServer publishes a JSON Web Key Set over TLS and signs tokens with
+	// the current key, standing in for an instance's token issuer.
+	JWKSServer struct {
+		*httptest.Server
+
+		mu     sync.Mutex
+		n      int
+		signer jose.Signer
+		keys   jose.JSONWebKeySet
+	}
+)
+
+const JWKSPath = "/.well-known/jwks.json"
+
+func NewJWKSServer() (*JWKSServer, error) {
+	s := &JWKSServer{}
+
+	if err := s.Rotate(); err != nil {
+		return nil, err
+	}
+
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != JWKSPath {
+			http.NotFound(w, r)
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.keys)
+	}))
+
+	return s, nil
+}
+
+// JWKSURL is the URL of the published key set.
+func (s *JWKSServer) JWKSURL() string {
+	return s.URL + JWKSPath
+}
+
+// Rotate signs with a new key from now on; previous keys stay published.
+func (s *JWKSServer) Rotate() error {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.n++
+	kid := fmt.Sprintf("key-%d", s.n)
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: jose.JSONWebKey{Key: priv, KeyID: kid}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		return err
+	}
+
+	s.signer = signer
+	s.keys.Keys = append(s.keys.Keys, jose.JSONWebKey{
+		Key:       priv.Public(),
+		KeyID:     kid,
+		Algorithm: string(jose.ES256),
+		Use:       "sig",
+	})
+
+	return nil
+}
+
+// Sign returns a compact token carrying claims, which may be any value that
+// encodes to a JSON object.
+func (s *JWKSServer) Sign(claims any) (string, error) {
+	s.mu.Lock()
+	signer := s.signer
+	s.mu.Unlock()
+
+	return jwt.Signed(signer).Claims(claims).Serialize()
+}
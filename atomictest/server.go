@@ -0,0 +1,479 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomictest
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libatomic/atomic-go"
+)
+
+type (
+	// Server is a fake atomic API served over TLS. It answers the /api/1.0.0
+	// route of every operation from a Store and issues bearer tokens from
+	// /oauth/token, so requests take the same HTTP path as in production.
+	Server struct {
+		*httptest.Server
+
+		// ClientID and ClientSecret are the credentials /oauth/token accepts
+		ClientID     string
+		ClientSecret string
+
+		store    Store
+		uploads  *UploadServer
+		latency  time.Duration
+		tokenTTL time.Duration
+
+		mu       sync.Mutex
+		tokens   map[string]time.Time
+		refresh  map[string]bool
+		faults   []*fault
+		requests []Request
+	}
+
+	ServerOption func(*Server)
+
+	// Fault scripts the failure of requests to Operation, or of every request
+	// when it is empty.
+	Fault struct {
+		Operation string
+		// Delay is waited before the request is answered
+		Delay time.Duration
+		// Status fails the request with an Error of that status, zero lets
+		// the request through after the delay
+		Status  int
+		Code    string
+		Message string
+		// Times limits the fault to the next n matching requests, zero keeps
+		// it until ClearFaults
+		Times int
+	}
+
+	// Request is an API request received by a Server.
+	Request struct {
+		Method string
+		Path   string
+		Header http.Header
+		Body   []byte
+	}
+
+	fault struct {
+		Fault
+		left int
+	}
+)
+
+const (
+	// DefaultTokenTTL is the lifetime of the access tokens a Server issues.
+	DefaultTokenTTL = time.Hour
+)
+
+// WithStore serves resources from store rather than a new Backend.
+func WithStore(store Store) ServerOption {
+	return func(s *Server) {
+		s.store = store
+	}
+}
+
+// WithCredentials sets the client credentials accepted by /oauth/token.
+func WithCredentials(clientID, clientSecret string) ServerOption {
+	return func(s *Server) {
+		s.ClientID = clientID
+		s.ClientSecret = clientSecret
+	}
+}
+
+// WithLatency delays every API response by d.
+func WithLatency(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.latency = d
+	}
+}
+
+// WithTokenTTL sets the lifetime of issued access tokens.
+func WithTokenTTL(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.tokenTTL = d
+	}
+}
+
+// NewServer starts a Server; the caller must Close it.
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
+		ClientID:     "atomictest",
+		ClientSecret: rand.Text(),
+		store:        NewBackend(),
+		tokenTTL:     DefaultTokenTTL,
+		tokens:       make(map[string]time.Time),
+		refresh:      make(map[string]bool),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// a Backend keeps uploaded assets with its resources, other stores get
+	// a standalone upload server
+	if _, ok := s.store.(*Backend); !ok {
+		s.uploads = NewUploadServer()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth/token", s.token)
+	mux.HandleFunc("/api/1.0.0/", s.api)
+
+	s.Server = httptest.NewTLSServer(mux)
+
+	return s
+}
+
+// Client returns a client for s that authenticates with its client
+// credentials; opts are applied after the defaults.
+func (s *Server) Client(opts ...atomic.ApiOption) *atomic.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate())
+
+	return atomic.New(append([]atomic.ApiOption{
+		atomic.WithBaseURL(s.URL),
+		atomic.WithRootCAs(pool),
+		atomic.WithClientCredentials(s.ClientID, s.ClientSecret),
+	}, opts...)...)
+}
+
+// Store returns the resources behind s.
+func (s *Server) Store() Store {
+	return s.store
+}
+
+// Uploads returns the server handling resumable asset uploads; with a
+// Backend store it is the Backend's.
+func (s *Server) Uploads() *UploadServer {
+	if b, ok := s.store.(*Backend); ok {
+		return b.Uploads()
+	}
+
+	return s.uploads
+}
+
+// IssueToken mints an access token s accepts, e.g. for atomic.WithToken.
+func (s *Server) IssueToken() string {
+	return s.issue()["access_token"].(string)
+}
+
+// ExpireTokens invalidates every access token issued so far; refresh tokens
+// stay valid.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.tokens)
+}
+
+// Inject adds a scripted fault.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, &fault{Fault: f, left: f.Times})
+}
+
+// ClearFaults removes every scripted fault.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = nil
+}
+
+// Requests returns the API requests received, oldest first.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) api(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Path:   r.URL.RequestURI(),
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+	s.mu.Unlock()
+
+	op, ok := atomic.LookupOperation(r.Method, r.URL.Path)
+	if !ok {
+		writeAPIError(w, apiError(http.StatusNotFound, "not_found", "no route for "+r.Method+" "+r.URL.Path))
+		return
+	}
+
+	if wait(r.Context(), s.latency) != nil {
+		return
+	}
+
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeAPIError(w, apiError(http.StatusUnauthorized, "unauthorized", "invalid or expired access token"))
+		return
+	}
+
+	f := s.fault(op.Name)
+
+	if wait(r.Context(), f.Delay) != nil {
+		return
+	}
+
+	if f.Status != 0 {
+		writeAPIError(w, atomic.Error{
+			Code:       cmp.Or(f.Code, statusCode(f.Status)),
+			Message:    cmp.Or(f.Message, "injected fault"),
+			StatusCode: f.Status,
+		})
+		return
+	}
+
+	w.Header().Set(atomic.RequestIDHeader, newID())
+
+	if strings.HasPrefix(op.Name, "AssetUpload") {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		s.Uploads().ServeHTTP(w, r)
+		return
+	}
+
+	params, err := requestParams(r, body)
+	if err != nil {
+		writeAPIError(w, apiError(http.StatusBadRequest, "bad_request", err.Error()))
+		return
+	}
+
+	v, err := s.store.Exec(r.Context(), Call{
+		Operation: op,
+		Method:    r.Method,
+		Path:      r.URL.RequestURI(),
+		Instance:  r.Header.Get("Atomic-Instance"),
+		Params:    params,
+	})
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, v)
+}
+
+// fault returns the first scripted fault for operation, consuming one of its
+// uses; the zero value means none.
+func (s *Server) fault(operation string) Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, f := range s.faults {
+		if f.Operation != "" && f.Operation != operation {
+			continue
+		}
+
+		if f.Times > 0 {
+			if f.left--; f.left == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+
+		return f.Fault
+	}
+
+	return Fault{}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires, ok := s.tokens[token]
+
+	return ok && time.Now().Before(expires)
+}
+
+// token implements the client_credentials and refresh_token grants.
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+		if id != s.ClientID || secret != s.ClientSecret {
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client")
+			return
+		}
+
+	case "refresh_token":
+		s.mu.Lock()
+		ok := s.refresh[r.PostForm.Get("refresh_token")]
+		s.mu.Unlock()
+
+		if !ok {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.issue())
+}
+
+func (s *Server) issue() map[string]any {
+	access, refresh := rand.Text(), rand.Text()
+
+	s.mu.Lock()
+	s.tokens[access] = time.Now().Add(s.tokenTTL)
+	s.refresh[refresh] = true
+	s.mu.Unlock()
+
+	return map[string]any{
+		"access_token":  access,
+		"token_type":    "Bearer",
+		"expires_in":    int(s.tokenTTL.Seconds()),
+		"refresh_token": refresh,
+	}
+}
+
+// requestParams merges the query and the JSON or multipart body of r into a
+// single JSON object; uploaded files are described by name, type and size.
+func requestParams(r *http.Request, body []byte) (json.RawMessage, error) {
+	params := make(map[string]any)
+
+	for k, v := range r.URL.Query() {
+		if len(v) == 1 {
+			params[k] = v[0]
+		} else {
+			params[k] = v
+		}
+	}
+
+	mediaType, mt, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch {
+	case len(body) == 0:
+
+	case mediaType == "multipart/form-data":
+		mr := multipart.NewReader(bytes.NewReader(body), mt["boundary"])
+
+		for {
+			part, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			data, err := io.ReadAll(part)
+			if err != nil {
+				return nil, err
+			}
+
+			if part.FileName() == "" {
+				params[part.FormName()] = string(data)
+				continue
+			}
+
+			params["filename"] = part.FileName()
+			params["mime_type"] = part.Header.Get("Content-Type")
+			params["size"] = len(data)
+		}
+
+	default:
+		if err := json.Unmarshal(body, &params); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(params)
+}
+
+// wait waits for d unless ctx is done first.
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// statusCode derives an error code from an HTTP status, e.g.
+// service_unavailable.
+func statusCode(status int) string {
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	var e atomic.Error
+	if !errors.As(err, &e) {
+		e = atomic.Error{Code: "internal", Message: err.Error()}
+	}
+
+	writeJSON(w, cmp.Or(e.StatusCode, http.StatusInternalServerError), e)
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
@@ -0,0 +1,124 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomictest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libatomic/atomic-go"
+	"github.com/libatomic/atomic-go/atomictest"
+)
+
+const testJobID = "7a1c2e3f-9b8d-4c6a-8e2f-1d3c5b7a9e01"
+
+func newTestServer(t *testing.T) (*atomictest.Server, *atomictest.Backend, *atomic.Client) {
+	t.Helper()
+
+	b := atomictest.NewBackend()
+	if err := b.Seed("User", map[string]any{"id": testUserID}); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := atomictest.NewServer(atomictest.WithStore(b))
+	t.Cleanup(srv.Close)
+
+	return srv, b, srv.Client(atomic.WithRetryPolicy(atomic.RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+	}))
+}
+
+func TestServerRefreshesExpiredTokens(t *testing.T) {
+	srv, b, client := newTestServer(t)
+	ctx := context.Background()
+
+	for range 2 {
+		if _, err := client.UserGet(ctx, &atomic.UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+			t.Fatal(err)
+		}
+		srv.ExpireTokens()
+	}
+
+	if n := len(b.CallsTo("UserGet")); n != 2 {
+		t.Errorf("UserGet calls = %d, want 2", n)
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	srv, _, client := newTestServer(t)
+	ctx := context.Background()
+
+	srv.Inject(atomictest.Fault{Operation: "UserGet", Status: http.StatusServiceUnavailable, Times: 1})
+	if _, err := client.UserGet(ctx, &atomic.UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatalf("the 503 should be retried: %v", err)
+	}
+
+	srv.Inject(atomictest.Fault{Operation: "UserGet", Status: http.StatusNotFound, Times: 1})
+	if _, err := client.UserGet(ctx, &atomic.UserGetInput{UserID: testID(t, testUserID)}); !errors.Is(err, atomic.ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+
+	if n := len(srv.Requests()); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+}
+
+// run with -race: responses are encoded after the store returns, while
+// restarts keep rewriting the stored job
+func TestServerConcurrentGetAndRestart(t *testing.T) {
+	_, b, client := newTestServer(t)
+	ctx := context.Background()
+
+	if err := b.Seed("Job", map[string]any{"id": testJobID, "status": "pending"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+
+	for range 4 {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				if _, err := client.JobGet(ctx, &atomic.JobGetInput{JobID: testID(t, testJobID)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				if _, err := client.JobRestart(ctx, &atomic.JobRestartInput{JobID: testID(t, testJobID)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,317 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package atomictest provides local stand-ins for the atomic API that can be
+// used to exercise clients without network access.
+package atomictest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/libatomic/atomic-go"
+)
+
+type (
+	// UploadServer is an in-memory implementation of the resumable asset
+	// upload protocol. It is an http.Handler; Start serves it over TLS.
+	UploadServer struct {
+		// FailPart, if set, is consulted before a part is stored; a non-zero
+		// status fails the request with that status.
+		FailPart func(uploadID string, number int) int
+
+		// ChunkSize, if set, replaces the chunk size asked for by clients.
+		ChunkSize int64
+
+		// OnComplete, if set, is called with every asset assembled from an
+		// upload before it is returned; an error fails the request. Backend
+		// uses it to store the asset with its other resources.
+		OnComplete func(asset map[string]any) error
+
+		mux     *http.ServeMux
+		mu      sync.Mutex
+		uploads map[string]*upload
+		assets  map[string][]byte
+	}
+
+	upload struct {
+		atomic.AssetUpload
+		parts map[int][]byte
+	}
+)
+
+func NewUploadServer() *UploadServer {
+	s := &UploadServer{
+		mux:     http.NewServeMux(),
+		uploads: make(map[string]*upload),
+		assets:  make(map[string][]byte),
+	}
+
+	s.mux.HandleFunc("POST /api/1.0.0/assets/uploads", s.create)
+	s.mux.HandleFunc("GET /api/1.0.0/assets/uploads/{id}", s.get)
+	s.mux.HandleFunc("PUT /api/1.0.0/assets/uploads/{id}/parts/{n}", s.part)
+	s.mux.HandleFunc("POST /api/1.0.0/assets/uploads/{id}/complete", s.complete)
+
+	return s
+}
+
+// Start serves s from an httptest TLS server; the caller must Close it.
+func (s *UploadServer) Start() *httptest.Server {
+	return httptest.NewTLSServer(s)
+}
+
+// Asset returns the assembled payload of a completed upload.
+func (s *UploadServer) Asset(id string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.assets[id]
+	return data, ok
+}
+
+// Parts returns the part numbers received so far for an upload.
+func (s *UploadServer) Parts(uploadID string) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.uploads[uploadID]
+	if !ok {
+		return nil
+	}
+
+	rval := make([]int, 0, len(u.parts))
+	for n := range u.parts {
+		rval = append(rval, n)
+	}
+	sort.Ints(rval)
+
+	return rval
+}
+
+func (s *UploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *UploadServer) create(w http.ResponseWriter, r *http.Request) {
+	var in atomic.AssetUploadCreateInput
+
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := in.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if s.ChunkSize > 0 {
+		in.ChunkSize = s.ChunkSize
+	}
+
+	s.mu.Lock()
+	u := &upload{
+		AssetUpload: atomic.AssetUpload{
+			ID:        newID(),
+			Filename:  in.Filename,
+			MimeType:  in.MimeType,
+			Size:      in.Size,
+			ChunkSize: in.ChunkSize,
+		},
+		parts: make(map[int][]byte),
+	}
+	s.uploads[u.ID] = u
+	created := u.AssetUpload
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *UploadServer) get(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.uploads[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "upload not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, u.status())
+}
+
+func (s *UploadServer) part(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || n < 1 {
+		writeError(w, http.StatusBadRequest, "invalid part number")
+		return
+	}
+
+	if s.FailPart != nil {
+		if status := s.FailPart(id, n); status != 0 {
+			writeError(w, status, "injected failure")
+			return
+		}
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sum := checksum(data)
+	if want := r.Header.Get(atomic.ChecksumHeader); want != "" && want != sum {
+		writeError(w, http.StatusBadRequest, "checksum mismatch")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "upload not found")
+		return
+	}
+
+	if int64(len(data)) != u.partSize(n) {
+		writeError(w, http.StatusBadRequest, "invalid part size")
+		return
+	}
+
+	u.parts[n] = data
+
+	writeJSON(w, http.StatusOK, atomic.AssetUploadPart{
+		Number:   n,
+		Size:     int64(len(data)),
+		Checksum: sum,
+	})
+}
+
+func (s *UploadServer) complete(w http.ResponseWriter, r *http.Request) {
+	var in atomic.AssetUploadCompleteInput
+
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.uploads[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "upload not found")
+		return
+	}
+
+	count := u.partCount()
+	if len(in.Parts) != count {
+		writeError(w, http.StatusBadRequest, "missing parts")
+		return
+	}
+
+	var buf bytes.Buffer
+
+	for i, part := range in.Parts {
+		data, ok := u.parts[i+1]
+		if part.Number != i+1 || !ok || checksum(data) != part.Checksum {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("part %d does not match", i+1))
+			return
+		}
+		buf.Write(data)
+	}
+
+	asset := map[string]any{
+		"id":        u.ID,
+		"filename":  u.Filename,
+		"mime_type": u.MimeType,
+		"size":      u.Size,
+	}
+
+	if s.OnComplete != nil {
+		if err := s.OnComplete(asset); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	s.assets[u.ID] = buf.Bytes()
+	delete(s.uploads, u.ID)
+
+	writeJSON(w, http.StatusCreated, asset)
+}
+
+func (u *upload) partCount() int {
+	return int((u.Size + u.ChunkSize - 1) / u.ChunkSize)
+}
+
+func (u *upload) partSize(n int) int64 {
+	if n > u.partCount() {
+		return -1
+	}
+	return min(u.ChunkSize, u.Size-int64(n-1)*u.ChunkSize)
+}
+
+func (u *upload) status() atomic.AssetUpload {
+	rval := u.AssetUpload
+	rval.Parts = make([]atomic.AssetUploadPart, 0, len(u.parts))
+
+	for n, data := range u.parts {
+		rval.Parts = append(rval.Parts, atomic.AssetUploadPart{
+			Number:   n,
+			Size:     int64(len(data)),
+			Checksum: checksum(data),
+		})
+	}
+
+	sort.Slice(rval.Parts, func(i, j int) bool {
+		return rval.Parts[i].Number < rval.Parts[j].Number
+	})
+
+	return rval
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]any{
+		"code":    http.StatusText(status),
+		"message": msg,
+	})
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
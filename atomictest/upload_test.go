@@ -0,0 +1,255 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomictest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libatomic/atomic-go"
+	"github.com/libatomic/atomic-go/atomictest"
+)
+
+func randomPayload(t *testing.T, n int) []byte {
+	t.Helper()
+
+	data := make([]byte, n)
+	rand.Read(data)
+
+	return data
+}
+
+func partNumbers(calls []atomictest.Call) []int {
+	var rval []int
+	for _, c := range calls {
+		n, _ := strconv.Atoi(path.Base(c.Path))
+		rval = append(rval, n)
+	}
+
+	return rval
+}
+
+func TestResumableUploadResumes(t *testing.T) {
+	client, b := atomictest.NewClient()
+
+	uploads := b.Uploads()
+	// the server settles on a different chunk size than requested
+	uploads.ChunkSize = 1000
+
+	interrupted := true
+	uploads.FailPart = func(uploadID string, number int) int {
+		if number == 4 && interrupted {
+			return http.StatusBadRequest
+		}
+		return 0
+	}
+
+	data := randomPayload(t, 5500)
+
+	in := &atomic.AssetCreateResumableInput{
+		Payload:   bytes.NewReader(data),
+		Filename:  "payload.bin",
+		MimeType:  "application/octet-stream",
+		Size:      int64(len(data)),
+		ChunkSize: 1024,
+		StateFile: filepath.Join(t.TempDir(), "payload.upload"),
+	}
+
+	if _, err := client.AssetCreateResumable(context.Background(), in); err == nil {
+		t.Fatal("expected the interrupted upload to fail")
+	}
+
+	before := len(b.CallsTo("AssetUploadPart"))
+	interrupted = false
+
+	var progress []int64
+	in.Progress = func(sent, total int64) { progress = append(progress, sent) }
+
+	asset, err := client.AssetCreateResumable(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(b.CallsTo("AssetUploadCreate")); n != 1 {
+		t.Errorf("upload created %d times, want it resumed", n)
+	}
+
+	if got := partNumbers(b.CallsTo("AssetUploadPart")[before:]); len(got) != 3 || got[0] != 4 || got[2] != 6 {
+		t.Errorf("resumed parts = %v, want [4 5 6]", got)
+	}
+
+	if len(progress) != 3 || progress[0] != 4000 || progress[2] != 5500 {
+		t.Errorf("progress = %v", progress)
+	}
+
+	got, ok := uploads.Asset(asset.ID.String())
+	if !ok || !bytes.Equal(got, data) {
+		t.Fatalf("assembled asset differs (%d bytes)", len(got))
+	}
+
+	// the asset is stored with the backend's other resources
+	if _, err := client.AssetGet(context.Background(), &atomic.AssetGetInput{AssetID: &asset.ID}); err != nil {
+		t.Errorf("AssetGet: %v", err)
+	}
+}
+
+func TestResumableUploadResendsChangedParts(t *testing.T) {
+	client, b := atomictest.NewClient()
+
+	uploads := b.Uploads()
+	uploads.ChunkSize = 1000
+
+	interrupted := true
+	uploads.FailPart = func(uploadID string, number int) int {
+		if number == 4 && interrupted {
+			return http.StatusBadRequest
+		}
+		return 0
+	}
+
+	data := randomPayload(t, 5500)
+
+	in := &atomic.AssetCreateResumableInput{
+		Payload:   bytes.NewReader(data),
+		Filename:  "payload.bin",
+		Size:      int64(len(data)),
+		ChunkSize: 1000,
+		StateFile: filepath.Join(t.TempDir(), "payload.upload"),
+	}
+
+	if _, err := client.AssetCreateResumable(context.Background(), in); err == nil {
+		t.Fatal("expected the interrupted upload to fail")
+	}
+
+	before := len(b.CallsTo("AssetUploadPart"))
+	interrupted = false
+
+	// the file changed inside part 2 between the runs
+	data = bytes.Clone(data)
+	data[1500] ^= 0xff
+	in.Payload = bytes.NewReader(data)
+
+	asset, err := client.AssetCreateResumable(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := partNumbers(b.CallsTo("AssetUploadPart")[before:]); !slices.Equal(got, []int{2, 4, 5, 6}) {
+		t.Errorf("resumed parts = %v, want [2 4 5 6]", got)
+	}
+
+	got, ok := uploads.Asset(asset.ID.String())
+	if !ok || !bytes.Equal(got, data) {
+		t.Fatalf("assembled asset differs (%d bytes)", len(got))
+	}
+}
+
+func TestResumableUploadShortPayload(t *testing.T) {
+	client, b := atomictest.NewClient()
+
+	_, err := client.AssetCreateResumable(context.Background(), &atomic.AssetCreateResumableInput{
+		Payload:   bytes.NewReader(randomPayload(t, 1500)),
+		Filename:  "payload.bin",
+		Size:      3000,
+		ChunkSize: 1000,
+	})
+
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("err = %v", err)
+	}
+
+	if n := len(b.CallsTo("AssetUploadPart")); n != 1 {
+		t.Errorf("sent %d parts", n)
+	}
+}
+
+func TestResumableUploadChecksumMismatch(t *testing.T) {
+	uploads := atomictest.NewUploadServer()
+
+	corrupt := false
+
+	// a server that stores the part but reports another checksum
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		uploads.ServeHTTP(rec, r)
+
+		body := rec.Body.Bytes()
+
+		if corrupt && r.Method == http.MethodPut {
+			var part atomic.AssetUploadPart
+			json.Unmarshal(body, &part)
+			part.Checksum = strings.Repeat("0", 64)
+			body, _ = json.Marshal(part)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(rec.Code)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	client := atomic.New(
+		atomic.WithBaseURL(srv.URL),
+		atomic.WithHTTPClient(srv.Client()),
+		atomic.WithRetryPolicy(atomic.RetryPolicy{MaxAttempts: 1, MinBackoff: time.Millisecond}),
+	)
+
+	in := func() *atomic.AssetCreateResumableInput {
+		return &atomic.AssetCreateResumableInput{
+			Payload:   bytes.NewReader(randomPayload(t, 2500)),
+			Filename:  "payload.bin",
+			Size:      2500,
+			ChunkSize: 1000,
+		}
+	}
+
+	// the client rejects an echoed checksum that differs from its own
+	corrupt = true
+	if _, err := client.AssetCreateResumable(context.Background(), in()); !errors.Is(err, atomic.ErrChecksumMismatch) {
+		t.Errorf("client side: err = %v", err)
+	}
+	corrupt = false
+
+	// the server rejects a part whose data doesn't match the checksum sent
+	ctx := atomic.ContextWithRequestHook(context.Background(), func(req *http.Request) error {
+		if req.Method == http.MethodPut {
+			req.Header.Set(atomic.ChecksumHeader, strings.Repeat("f", 64))
+		}
+		return nil
+	})
+
+	_, err := client.AssetCreateResumable(ctx, in())
+
+	var e atomic.Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusBadRequest || !strings.Contains(e.Message, "checksum") {
+		t.Errorf("server side: err = %v", err)
+	}
+}
@@ -20,6 +20,7 @@ package atomic
 import (
 	"context"
 	"fmt"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -110,3 +111,9 @@ func (c *Client) AudienceList(ctx context.Context, params *AudienceListInput) ([
 
 	return resp.Value(), nil
 }
+
+func (c *Client) AudienceListAll(ctx context.Context, params *AudienceListInput, opts ...PageOption) iter.Seq2[*Audience, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*Audience, error) {
+		return c.AudienceList(ctx, params)
+	})
+}
@@ -20,6 +20,7 @@ package atomic
 import (
 	"context"
 	"fmt"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -110,3 +111,9 @@ func (c *Client) CategoryList(ctx context.Context, params *CategoryListInput) ([
 
 	return resp.Value(), nil
 }
+
+func (c *Client) CategoryListAll(ctx context.Context, params *CategoryListInput, opts ...PageOption) iter.Seq2[*Category, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*Category, error) {
+		return c.CategoryList(ctx, params)
+	})
+}
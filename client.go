@@ -20,11 +20,14 @@ package atomic
 type (
 	Client struct {
 		Backend Backend
+
+		userTokens *userTokenCache
 	}
 )
 
 func NewClient(backend Backend) *Client {
 	return &Client{
-		Backend: backend,
+		Backend:    backend,
+		userTokens: newUserTokenCache(maxUserTokens),
 	}
 }
@@ -20,6 +20,7 @@ package atomic
 import (
 	"context"
 	"fmt"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -100,6 +101,12 @@ func (c *Client) CreditList(ctx context.Context, params *CreditListInput) ([]*Cr
 	return resp.Value(), nil
 }
 
+func (c *Client) CreditListAll(ctx context.Context, params *CreditListInput, opts ...PageOption) iter.Seq2[*Credit, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*Credit, error) {
+		return c.CreditList(ctx, params)
+	})
+}
+
 func (c *Client) CreditInviteCreate(ctx context.Context, params *CreditInviteCreateInput) (*CreditInvite, error) {
 	var resp ResponseProxy[CreditInvite]
 
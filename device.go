@@ -0,0 +1,92 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+type (
+	DeviceConfig struct {
+		// Host is the API host or base URL, DefaultAPIHost if empty
+		Host         string
+		ClientID     string
+		ClientSecret string
+		Scopes       []string
+		HTTPClient   *http.Client
+	}
+
+	// DeviceLogin signs users in with the device authorization grant
+	// (RFC 8628), for tools that can't open a browser themselves.
+	DeviceLogin struct {
+		Config oauth2.Config
+		client *http.Client
+	}
+
+	// DevicePrompt shows the user where to go and which code to enter.
+	DevicePrompt func(*oauth2.DeviceAuthResponse) error
+)
+
+func NewDeviceLogin(cfg DeviceConfig) *DeviceLogin {
+	if cfg.Host == "" {
+		cfg.Host = DefaultAPIHost
+	}
+
+	return &DeviceLogin{
+		Config: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     Endpoint(cfg.Host),
+			Scopes:       cfg.Scopes,
+		},
+		client: cfg.HTTPClient,
+	}
+}
+
+// Login requests a device code, hands it to prompt and polls the token
+// endpoint until the user approves or denies the request, the code expires
+// or ctx is done. Polling honors the server interval and backs off on
+// slow_down.
+func (l *DeviceLogin) Login(ctx context.Context, prompt DevicePrompt) (*oauth2.Token, error) {
+	ctx = withHTTPClient(ctx, l.client)
+
+	da, err := l.Config.DeviceAuth(ctx)
+	if err != nil {
+		return nil, transportError(err)
+	}
+
+	if err := prompt(da); err != nil {
+		return nil, err
+	}
+
+	tok, err := l.Config.DeviceAccessToken(ctx, da)
+	if err != nil {
+		return nil, transportError(err)
+	}
+
+	return tok, nil
+}
+
+// TokenSource returns a source that refreshes tok with its refresh token when
+// it expires or is rejected, for use with WithTokenSource.
+func (l *DeviceLogin) TokenSource(ctx context.Context, tok *oauth2.Token) oauth2.TokenSource {
+	return newRefreshTokenSource(withHTTPClient(ctx, l.client), &l.Config, tok)
+}
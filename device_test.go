@@ -0,0 +1,128 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// newDeviceServer answers the token endpoint with pending responses until
+// the given number of polls, then with final.
+func newDeviceServer(t *testing.T, pending int, final func(w http.ResponseWriter)) (*httptest.Server, *int) {
+	t.Helper()
+
+	polls := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/device/code":
+			writeTestJSON(w, http.StatusOK, map[string]any{
+				"device_code":      "d1",
+				"user_code":        "ABCD-EFGH",
+				"verification_uri": "https://example.com/device",
+				"expires_in":       60,
+				"interval":         1,
+			})
+
+		case "/oauth/token":
+			r.ParseForm()
+			if r.Form.Get("device_code") != "d1" {
+				writeTestJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant"})
+				return
+			}
+
+			if polls++; polls <= pending {
+				writeTestJSON(w, http.StatusBadRequest, map[string]any{"error": "authorization_pending"})
+				return
+			}
+
+			final(w)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &polls
+}
+
+func TestDeviceLogin(t *testing.T) {
+	srv, polls := newDeviceServer(t, 1, func(w http.ResponseWriter) {
+		writeTestJSON(w, http.StatusOK, map[string]any{
+			"access_token":  "a1",
+			"refresh_token": "r1",
+			"token_type":    "Bearer",
+			"expires_in":    60,
+		})
+	})
+
+	l := NewDeviceLogin(DeviceConfig{Host: srv.URL, ClientID: "client"})
+
+	var prompt *oauth2.DeviceAuthResponse
+
+	tok, err := l.Login(context.Background(), func(da *oauth2.DeviceAuthResponse) error {
+		prompt = da
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tok.AccessToken != "a1" || *polls != 2 {
+		t.Errorf("token = %v, polls = %d", tok, *polls)
+	}
+
+	if prompt == nil || prompt.UserCode != "ABCD-EFGH" || prompt.VerificationURI != "https://example.com/device" {
+		t.Errorf("prompt = %+v", prompt)
+	}
+}
+
+func TestDeviceLoginDenied(t *testing.T) {
+	srv, _ := newDeviceServer(t, 0, func(w http.ResponseWriter) {
+		writeTestJSON(w, http.StatusBadRequest, map[string]any{"error": "access_denied"})
+	})
+
+	l := NewDeviceLogin(DeviceConfig{Host: srv.URL, ClientID: "client"})
+
+	_, err := l.Login(context.Background(), func(*oauth2.DeviceAuthResponse) error { return nil })
+
+	var e Error
+	if !errors.As(err, &e) || e.Code != "access_denied" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestDeviceLoginPromptError(t *testing.T) {
+	srv, polls := newDeviceServer(t, 0, func(w http.ResponseWriter) {})
+
+	l := NewDeviceLogin(DeviceConfig{Host: srv.URL, ClientID: "client"})
+
+	errPrompt := errors.New("no terminal")
+
+	if _, err := l.Login(context.Background(), func(*oauth2.DeviceAuthResponse) error { return errPrompt }); !errors.Is(err, errPrompt) {
+		t.Fatalf("err = %v", err)
+	}
+
+	if *polls != 0 {
+		t.Errorf("polled %d times after the prompt failed", *polls)
+	}
+}
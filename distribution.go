@@ -20,6 +20,7 @@ package atomic
 import (
 	"context"
 	"fmt"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -110,3 +111,9 @@ func (c *Client) DistributionList(ctx context.Context, params *DistributionListI
 
 	return resp.Value(), nil
 }
+
+func (c *Client) DistributionListAll(ctx context.Context, params *DistributionListInput, opts ...PageOption) iter.Seq2[*Distribution, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*Distribution, error) {
+		return c.DistributionList(ctx, params)
+	})
+}
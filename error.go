@@ -17,7 +17,15 @@
 
 package atomic
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
 
 type (
 	Error struct {
@@ -27,11 +35,35 @@ type (
 		// callers still get useful output when the server returns a body that
 		// doesn't include a Message.
 		Status string `json:"-"`
+		// StatusCode is the HTTP status code, zero for errors raised before a
+		// response was received.
+		StatusCode int `json:"-"`
 		// Raw is the original response body when it existed but couldn't be
 		// decoded into the standard shape. Helps surface non-standard error
 		// envelopes (e.g. oauth2 responses).
 		Raw string `json:"-"`
+		// RequestID is the server assigned id of the failed call, if any.
+		RequestID string `json:"-"`
+		// Fields holds per-field validation messages keyed by field name,
+		// nested fields are joined with a dot (e.g. "address.zip").
+		Fields FieldErrors `json:"fields,omitempty"`
+
+		err error
 	}
+
+	// FieldErrors decodes the per-field details of a validation failure. The
+	// server may send them as a (possibly nested) object of messages or as a
+	// list of {field, message} entries.
+	FieldErrors map[string]string
+)
+
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrConflict     = errors.New("conflict")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrValidation   = errors.New("validation failed")
 )
 
 // Error never returns an empty string so wrappers like fmt.Errorf("x: %w", e)
@@ -48,9 +80,123 @@ func (e Error) Error() string {
 		return e.Code
 	case e.Raw != "":
 		return e.Raw
+	case len(e.Fields) > 0:
+		return e.Fields.String()
 	case e.Status != "":
 		return e.Status
 	default:
 		return "unknown error"
 	}
 }
+
+// Is matches the sentinel errors against the status code and validation
+// details, so errors.Is(err, atomic.ErrNotFound) works for every call.
+func (e Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrValidation:
+		// a bare 400 is a malformed request, not necessarily invalid input
+		return len(e.Fields) > 0 || e.Code == ValidationErrorCode
+	}
+
+	return false
+}
+
+// Unwrap returns the underlying cause, e.g. the oauth2 error of a failed
+// token exchange.
+func (e Error) Unwrap() error {
+	return e.err
+}
+
+// Retryable reports whether the failure is transient and the call may
+// succeed if repeated, using the classification of the default RetryPolicy.
+func (e Error) Retryable() bool {
+	if e.StatusCode == 0 && e.err != nil {
+		return isTransientError(e.err)
+	}
+
+	return slices.Contains(defaultRetryableStatus, e.StatusCode)
+}
+
+// IsRetryable is Retryable for any error returned by a call, including
+// transport errors that never produced a response.
+func IsRetryable(err error) bool {
+	var e Error
+	if errors.As(err, &e) {
+		return e.Retryable()
+	}
+
+	return err != nil && isTransientError(err)
+}
+
+func (f *FieldErrors) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	out := make(FieldErrors)
+
+	switch val := v.(type) {
+	case map[string]any:
+		flattenFieldErrors(out, "", val)
+	case []any:
+		for _, item := range val {
+			m, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			field, _ := m["field"].(string)
+			msg, _ := m["message"].(string)
+			if field != "" {
+				out[field] = msg
+			}
+		}
+	}
+
+	*f = out
+
+	return nil
+}
+
+func (f FieldErrors) String() string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+": "+f[k])
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+func flattenFieldErrors(out FieldErrors, prefix string, m map[string]any) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch val := v.(type) {
+		case string:
+			out[key] = val
+		case map[string]any:
+			flattenFieldErrors(out, key, val)
+		default:
+			out[key] = fmt.Sprint(val)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestErrorSentinels(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		is     error
+	}{
+		{http.StatusNotFound, `{"code":"not_found","message":"no such user"}`, ErrNotFound},
+		{http.StatusUnauthorized, `{}`, ErrUnauthorized},
+		{http.StatusForbidden, `{}`, ErrForbidden},
+		{http.StatusConflict, `{"code":"exists"}`, ErrConflict},
+		{http.StatusTooManyRequests, ``, ErrRateLimited},
+		{http.StatusUnprocessableEntity, `{"fields":{"email":"must be valid"}}`, ErrValidation},
+		{http.StatusBadRequest, `{"code":"validation_failed"}`, ErrValidation},
+	}
+
+	sentinels := []error{ErrNotFound, ErrUnauthorized, ErrForbidden, ErrConflict, ErrRateLimited, ErrValidation}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.status), func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}, WithRetryPolicy(RetryPolicy{}))
+
+			_, err := c.UserCreate(context.Background(), &UserCreateInput{})
+
+			for _, s := range sentinels {
+				if got := errors.Is(err, s); got != (s == tt.is) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, s, got)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorBadRequestIsNotValidation(t *testing.T) {
+	e := Error{Code: "bad_request", StatusCode: http.StatusBadRequest}
+
+	if errors.Is(e, ErrValidation) {
+		t.Fatal("a bare 400 matched ErrValidation")
+	}
+}
+
+func TestErrorFields(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		io.WriteString(w, `{"code":"invalid","fields":{"email":"must be valid","address":{"zip":"required"}}}`)
+	})
+
+	_, err := c.UserCreate(context.Background(), &UserCreateInput{})
+
+	var e Error
+	if !errors.As(err, &e) {
+		t.Fatalf("err = %#v", err)
+	}
+
+	if e.Fields["email"] != "must be valid" || e.Fields["address.zip"] != "required" {
+		t.Fatalf("fields = %v", e.Fields)
+	}
+
+	var list FieldErrors
+	if err := list.UnmarshalJSON([]byte(`[{"field":"name","message":"required"}]`)); err != nil || list["name"] != "required" {
+		t.Fatalf("fields = %v, err = %v", list, err)
+	}
+}
+
+func TestErrorRawBody(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<html>denied</html>")
+	})
+
+	_, err := c.UserCreate(context.Background(), &UserCreateInput{})
+
+	var e Error
+	if !errors.As(err, &e) || e.Raw != "<html>denied</html>" || e.Error() != e.Raw {
+		t.Fatalf("err = %#v", err)
+	}
+}
+
+func TestErrorRetryable(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{Error{StatusCode: http.StatusServiceUnavailable}, true},
+		{Error{StatusCode: http.StatusTooManyRequests}, true},
+		{Error{StatusCode: http.StatusNotFound}, false},
+		{Error{err: reset}, true},
+		{Error{err: context.Canceled}, false},
+		{Error{Code: ValidationErrorCode}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.err.(Error).Retryable(); got != tt.want {
+			t.Errorf("%#v.Retryable() = %v", tt.err, got)
+		}
+
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%#v) = %v", tt.err, got)
+		}
+	}
+
+	if !IsRetryable(fmt.Errorf("get: %w", reset)) || IsRetryable(errors.New("boom")) || IsRetryable(nil) {
+		t.Fatal("IsRetryable disagrees with the retry policy on transport errors")
+	}
+}
@@ -0,0 +1,451 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	FailoverConfig struct {
+		// Endpoints are base URLs or hosts in order of preference
+		Endpoints []string
+
+		// EjectAfter is the number of consecutive failures that takes an
+		// endpoint out of rotation for EjectFor, doubling on every repeated
+		// ejection up to MaxEjectFor
+		EjectAfter  int
+		EjectFor    time.Duration
+		MaxEjectFor time.Duration
+
+		// ProbePath is requested on every endpoint each ProbeInterval by Run;
+		// any status below 500 marks the endpoint healthy
+		ProbePath     string
+		ProbeInterval time.Duration
+
+		// Sticky keeps each Atomic-Instance on the endpoint that last served
+		// it while that endpoint stays healthy. At most MaxSticky instances
+		// are remembered, least recently used first out.
+		Sticky    bool
+		MaxSticky int
+
+		// FailoverMethods may be resent to another endpoint after a failure;
+		// the default is the idempotent methods. Requests with an idempotency
+		// key always may, and any request may when the connection could not
+		// be established.
+		FailoverMethods []string
+
+		// HTTPClient sends the probes, http.DefaultClient if nil
+		HTTPClient *http.Client
+	}
+
+	// EndpointPool routes requests across several API endpoints, tracking
+	// their health from request outcomes and optional active probes.
+	EndpointPool struct {
+		cfg       FailoverConfig
+		mu        sync.Mutex
+		endpoints []*endpoint
+		sticky    map[string]*list.Element
+		lru       *list.List
+	}
+
+	EndpointStatus struct {
+		URL          string
+		Healthy      bool
+		Failures     int
+		EjectedUntil time.Time
+	}
+
+	endpoint struct {
+		url          *url.URL
+		failures     int
+		ejections    int
+		ejectedUntil time.Time
+	}
+
+	stickyEntry struct {
+		instance string
+		ep       *endpoint
+	}
+
+	// poolTransport sends the token requests of a client whose base is the
+	// pool's primary endpoint to the endpoint the pool picks
+	poolTransport struct {
+		pool *EndpointPool
+		next http.RoundTripper
+	}
+
+	endpointContextKey struct{}
+)
+
+const (
+	DefaultEjectAfter    = 3
+	DefaultEjectFor      = 30 * time.Second
+	DefaultMaxEjectFor   = 5 * time.Minute
+	DefaultProbeInterval = 10 * time.Second
+	DefaultMaxSticky     = 10000
+)
+
+func NewEndpointPool(cfg FailoverConfig) (*EndpointPool, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("at least one endpoint is required")
+	}
+
+	if cfg.EjectAfter <= 0 {
+		cfg.EjectAfter = DefaultEjectAfter
+	}
+
+	if cfg.EjectFor <= 0 {
+		cfg.EjectFor = DefaultEjectFor
+	}
+
+	if cfg.MaxEjectFor <= 0 {
+		cfg.MaxEjectFor = DefaultMaxEjectFor
+	}
+
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = DefaultProbeInterval
+	}
+
+	if cfg.MaxSticky <= 0 {
+		cfg.MaxSticky = DefaultMaxSticky
+	}
+
+	if cfg.ProbePath == "" {
+		cfg.ProbePath = "/"
+	}
+
+	p := &EndpointPool{
+		cfg:    cfg,
+		sticky: make(map[string]*list.Element),
+		lru:    list.New(),
+	}
+
+	for _, s := range cfg.Endpoints {
+		u, err := baseURL(s)
+		if err != nil {
+			return nil, err
+		}
+
+		p.endpoints = append(p.endpoints, &endpoint{url: u})
+	}
+
+	return p, nil
+}
+
+// WithEndpointPool sends requests to the endpoints of p instead of the base
+// URL. Token requests are routed through the pool as well, unless a base URL
+// is set or the token URL points elsewhere.
+func WithEndpointPool(p *EndpointPool) ApiOption {
+	return func(c *ApiConfig) {
+		c.EndpointPool = p
+	}
+}
+
+// Run probes every endpoint each ProbeInterval until ctx is done.
+func (p *EndpointPool) Run(ctx context.Context) error {
+	t := time.NewTicker(p.cfg.ProbeInterval)
+	defer t.Stop()
+
+	for {
+		p.Probe(ctx)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Probe checks every endpoint once.
+func (p *EndpointPool) Probe(ctx context.Context) {
+	client := p.cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var wg sync.WaitGroup
+
+	for _, ep := range p.endpoints {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.url.JoinPath(p.cfg.ProbePath).String(), nil)
+			if err != nil {
+				return
+			}
+
+			resp, err := client.Do(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err == nil && resp.StatusCode < 500 {
+				p.recover(ep)
+			} else {
+				p.fail(ep)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Status reports the health of every endpoint.
+func (p *EndpointPool) Status() []EndpointStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+
+	rval := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, ep := range p.endpoints {
+		rval = append(rval, EndpointStatus{
+			URL:          ep.url.String(),
+			Healthy:      !ep.ejected(now),
+			Failures:     ep.failures,
+			EjectedUntil: ep.ejectedUntil,
+		})
+	}
+
+	return rval
+}
+
+// pick returns the endpoint for the next attempt, avoiding the endpoint that
+// just failed. When every endpoint is ejected the one returning soonest is
+// used, so requests are never refused outright.
+func (p *EndpointPool) pick(instance string, avoid *endpoint) *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+
+	if p.cfg.Sticky && instance != "" {
+		if e, ok := p.sticky[instance]; ok {
+			if ep := e.Value.(*stickyEntry).ep; ep != avoid && !ep.ejected(now) {
+				p.lru.MoveToFront(e)
+				return ep
+			}
+		}
+	}
+
+	var rval *endpoint
+
+	for _, ep := range p.endpoints {
+		if ep != avoid && !ep.ejected(now) {
+			rval = ep
+			break
+		}
+	}
+
+	if rval == nil {
+		candidates := slices.DeleteFunc(slices.Clone(p.endpoints), func(ep *endpoint) bool {
+			return ep == avoid
+		})
+		if len(candidates) == 0 {
+			candidates = p.endpoints
+		}
+
+		rval = slices.MinFunc(candidates, func(a, b *endpoint) int {
+			return a.ejectedUntil.Compare(b.ejectedUntil)
+		})
+	}
+
+	if p.cfg.Sticky && instance != "" {
+		p.stick(instance, rval)
+	}
+
+	return rval
+}
+
+// stick remembers ep for instance, evicting the least recently used
+// instance beyond MaxSticky.
+func (p *EndpointPool) stick(instance string, ep *endpoint) {
+	if e, ok := p.sticky[instance]; ok {
+		e.Value.(*stickyEntry).ep = ep
+		p.lru.MoveToFront(e)
+		return
+	}
+
+	p.sticky[instance] = p.lru.PushFront(&stickyEntry{instance: instance, ep: ep})
+
+	if p.lru.Len() > p.cfg.MaxSticky {
+		oldest := p.lru.Back()
+		p.lru.Remove(oldest)
+		delete(p.sticky, oldest.Value.(*stickyEntry).instance)
+	}
+}
+
+// unstick forgets every instance pinned to ep.
+func (p *EndpointPool) unstick(ep *endpoint) {
+	for e := p.lru.Front(); e != nil; {
+		next := e.Next()
+		if entry := e.Value.(*stickyEntry); entry.ep == ep {
+			p.lru.Remove(e)
+			delete(p.sticky, entry.instance)
+		}
+		e = next
+	}
+}
+
+// observe records the outcome of an attempt and reports whether it counts
+// against the endpoint.
+func (p *EndpointPool) observe(ep *endpoint, resp *http.Response, err error) bool {
+	failed := false
+
+	if err != nil {
+		failed = !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	} else {
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			failed = true
+		}
+	}
+
+	if failed {
+		p.fail(ep)
+	} else if err == nil {
+		p.recover(ep)
+	}
+
+	return failed
+}
+
+// canFailover reports whether req may be resent to another endpoint.
+func (p *EndpointPool) canFailover(req *http.Request, op Operation, err error) bool {
+	if !isRewindable(req) {
+		return false
+	}
+
+	if isDialError(err) || req.Header.Get(IdempotencyKeyHeader) != "" {
+		return true
+	}
+
+	if len(p.cfg.FailoverMethods) > 0 {
+		return slices.Contains(p.cfg.FailoverMethods, req.Method) && !op.Unsafe
+	}
+
+	return isIdempotent(req, op)
+}
+
+func (p *EndpointPool) fail(ep *endpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ep.failures++
+
+	if ep.failures >= p.cfg.EjectAfter && !ep.ejected(time.Now()) {
+		d := min(p.cfg.EjectFor<<min(ep.ejections, 16), p.cfg.MaxEjectFor)
+		ep.ejectedUntil = time.Now().Add(d)
+		ep.ejections++
+		ep.failures = 0
+
+		// pinned instances move on for good rather than piling up
+		p.unstick(ep)
+	}
+}
+
+func (p *EndpointPool) recover(ep *endpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ep.failures = 0
+	ep.ejections = 0
+	ep.ejectedUntil = time.Time{}
+}
+
+func (p *EndpointPool) primary() *url.URL {
+	return p.endpoints[0].url
+}
+
+func (e *endpoint) ejected(now time.Time) bool {
+	return now.Before(e.ejectedUntil)
+}
+
+// isDialError reports whether the connection could not be established, so
+// the request never reached the server.
+func isDialError(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+// RoundTrip implements http.RoundTripper, moving requests under the primary
+// endpoint to the picked one and on to the next when it cannot be dialed.
+func (t *poolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	primary := strings.TrimSuffix(t.pool.primary().String(), "/")
+
+	rest, ok := strings.CutPrefix(req.URL.String(), primary)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/") && !strings.HasPrefix(rest, "?")) {
+		return next.RoundTrip(req)
+	}
+
+	var failed *endpoint
+
+	for attempt := 1; ; attempt++ {
+		ep := t.pool.pick("", failed)
+
+		u, err := url.Parse(strings.TrimSuffix(ep.url.String(), "/") + rest)
+		if err != nil {
+			return nil, err
+		}
+
+		r := req.Clone(req.Context())
+		r.URL, r.Host = u, ""
+
+		if attempt > 1 && req.GetBody != nil {
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := next.RoundTrip(r)
+
+		if t.pool.observe(ep, resp, err) && isDialError(err) && attempt < len(t.pool.endpoints) &&
+			(req.Body == nil || req.GetBody != nil) {
+			failed = ep
+			continue
+		}
+
+		return resp, err
+	}
+}
+
+func contextWithEndpoint(ctx context.Context, ep *endpoint) context.Context {
+	return context.WithValue(ctx, endpointContextKey{}, ep.url)
+}
@@ -0,0 +1,222 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingServer answers every request with status and counts them
+type countingServer struct {
+	*httptest.Server
+
+	hits   atomic.Int32
+	status atomic.Int32
+}
+
+func newCountingServer(t *testing.T, status int) *countingServer {
+	t.Helper()
+
+	s := &countingServer{}
+	s.status.Store(int32(status))
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hits.Add(1)
+		writeTestJSON(w, int(s.status.Load()), map[string]any{"id": testUserID})
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func deadEndpoint(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	return "http://" + ln.Addr().String()
+}
+
+func newTestPool(t *testing.T, cfg FailoverConfig) *EndpointPool {
+	t.Helper()
+
+	cfg.ProbeInterval = time.Hour
+	pool, err := NewEndpointPool(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pool
+}
+
+func TestFailoverOnlyResendsSafeRequests(t *testing.T) {
+	a := newCountingServer(t, http.StatusServiceUnavailable)
+	b := newCountingServer(t, http.StatusOK)
+
+	pool := newTestPool(t, FailoverConfig{
+		Endpoints:  []string{deadEndpoint(t), a.URL, b.URL},
+		EjectAfter: 1,
+	})
+	c := New(WithEndpointPool(pool), WithToken("test-token"), WithRetryPolicy(testRetryPolicy(3)))
+
+	// the dead endpoint never saw the write, a did and must not be bypassed
+	if _, err := c.UserCreate(context.Background(), &UserCreateInput{}); err == nil {
+		t.Fatal("expected the 503 from a")
+	}
+	if a.hits.Load() != 1 || b.hits.Load() != 0 {
+		t.Fatalf("hits a=%d b=%d, want 1 and 0", a.hits.Load(), b.hits.Load())
+	}
+
+	if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+	if b.hits.Load() != 1 {
+		t.Errorf("b hits = %d, want 1", b.hits.Load())
+	}
+
+	st := pool.Status()
+	if st[0].Healthy || st[1].Healthy || !st[2].Healthy {
+		t.Errorf("status = %+v", st)
+	}
+}
+
+func TestProbeRecoversEndpoint(t *testing.T) {
+	a := newCountingServer(t, http.StatusServiceUnavailable)
+	pool := newTestPool(t, FailoverConfig{Endpoints: []string{a.URL}, EjectAfter: 1})
+
+	pool.Probe(context.Background())
+	if pool.Status()[0].Healthy {
+		t.Fatal("a should be ejected after a failed probe")
+	}
+
+	a.status.Store(http.StatusNotFound)
+	pool.Probe(context.Background())
+	if !pool.Status()[0].Healthy {
+		t.Error("a should recover after a probe below 500")
+	}
+}
+
+func TestStickyInstances(t *testing.T) {
+	pool := newTestPool(t, FailoverConfig{
+		Endpoints:  []string{"https://a.example.com", "https://b.example.com"},
+		EjectAfter: 1,
+		Sticky:     true,
+	})
+	a, b := pool.endpoints[0], pool.endpoints[1]
+
+	// i1 fails over to b and stays there while b is healthy
+	if ep := pool.pick("i1", a); ep != b {
+		t.Fatalf("pick = %v, want b", ep.url)
+	}
+	if ep := pool.pick("i1", nil); ep != b {
+		t.Fatalf("sticky pick = %v, want b", ep.url)
+	}
+
+	// ejecting b forgets the instances pinned to it
+	pool.fail(b)
+	if len(pool.sticky) != 0 || pool.lru.Len() != 0 {
+		t.Fatalf("sticky entries = %d, want 0", len(pool.sticky))
+	}
+
+	pool.recover(b)
+	if ep := pool.pick("i1", nil); ep != a {
+		t.Errorf("pick after ejection = %v, want a", ep.url)
+	}
+}
+
+func TestStickyIsBounded(t *testing.T) {
+	pool := newTestPool(t, FailoverConfig{
+		Endpoints: []string{"https://a.example.com", "https://b.example.com"},
+		Sticky:    true,
+		MaxSticky: 3,
+	})
+	b := pool.endpoints[1]
+
+	for i := range 3 {
+		pool.pick(fmt.Sprintf("i%d", i), pool.endpoints[0])
+	}
+
+	// touching i0 makes i1 the least recently used
+	pool.pick("i0", nil)
+	for i := 3; i < 10; i++ {
+		pool.pick(fmt.Sprintf("i%d", i), nil)
+		if len(pool.sticky) > 3 || pool.lru.Len() != len(pool.sticky) {
+			t.Fatalf("sticky entries = %d/%d, want at most 3", len(pool.sticky), pool.lru.Len())
+		}
+		if i == 3 {
+			if _, ok := pool.sticky["i1"]; ok {
+				t.Error("i1 should be evicted first")
+			}
+			if e, ok := pool.sticky["i0"]; !ok || e.Value.(*stickyEntry).ep != b {
+				t.Error("i0 should still be pinned to b")
+			}
+		}
+	}
+
+	if p := newTestPool(t, FailoverConfig{Endpoints: []string{"a.example.com"}}); p.cfg.MaxSticky != DefaultMaxSticky {
+		t.Errorf("default MaxSticky = %d, want %d", p.cfg.MaxSticky, DefaultMaxSticky)
+	}
+}
+
+func TestFailoverRoutesTokenRequests(t *testing.T) {
+	s := newTokenServer(t, "t1")
+
+	pool := newTestPool(t, FailoverConfig{Endpoints: []string{deadEndpoint(t), s.URL}})
+	c := New(WithEndpointPool(pool), WithClientCredentials("client", "secret"), WithRetryPolicy(testRetryPolicy(1)))
+
+	if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.issued != 1 || s.calls != 1 {
+		t.Errorf("issued = %d, calls = %d, want 1 and 1", s.issued, s.calls)
+	}
+
+	if st := pool.Status(); st[0].Failures != 2 {
+		t.Errorf("dead endpoint failures = %d, want one for the token and one for the request", st[0].Failures)
+	}
+}
+
+func TestFailoverDialErrorsDoNotUseAttempts(t *testing.T) {
+	b := newCountingServer(t, http.StatusServiceUnavailable)
+
+	pool := newTestPool(t, FailoverConfig{Endpoints: []string{deadEndpoint(t), b.URL}})
+	c := New(WithEndpointPool(pool), WithToken("test-token"), WithRetryPolicy(testRetryPolicy(2)))
+
+	if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err == nil {
+		t.Fatal("expected the 503 from b")
+	}
+
+	// both attempts reach b, each after skipping the dead endpoint
+	if b.hits.Load() != 2 {
+		t.Errorf("b hits = %d, want 2", b.hits.Load())
+	}
+	if st := pool.Status(); st[0].Failures != 2 {
+		t.Errorf("dead endpoint failures = %d, want 2", st[0].Failures)
+	}
+}
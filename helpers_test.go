@@ -0,0 +1,74 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/libatomic/atomic/pkg/atomic"
+)
+
+const (
+	testUserID = "0b0f5d6c-4a8e-4c1e-9b51-7f3d2d1f6a01"
+	testAppID  = "5c3e8f9a-1d2b-4e6f-8a7c-9b0d1e2f3a04"
+)
+
+// newTestClient returns a client for a server running h, retrying quickly.
+func newTestClient(t *testing.T, h http.HandlerFunc, opts ...ApiOption) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	return New(append([]ApiOption{
+		WithBaseURL(srv.URL),
+		WithToken("test-token"),
+		WithRetryPolicy(testRetryPolicy(3)),
+	}, opts...)...)
+}
+
+func testRetryPolicy(attempts int) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: attempts,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+	}
+}
+
+// testID decodes an ID the way the API sends it.
+func testID(t testing.TB, s string) *atomic.ID {
+	t.Helper()
+
+	var id atomic.ID
+	if err := json.Unmarshal([]byte(strconv.Quote(s)), &id); err != nil {
+		t.Fatal(err)
+	}
+
+	return &id
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,82 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+)
+
+type (
+	idempotencyKeyContextKey struct{}
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+)
+
+// WithIdempotencyKeys enables automatic Idempotency-Key generation for every
+// mutating call. The key is generated once per Client method call and reused
+// for all of its retry attempts, so the server can safely deduplicate them.
+func WithIdempotencyKeys() ApiOption {
+	return func(c *ApiConfig) {
+		c.IdempotencyKeys = true
+	}
+}
+
+// ContextWithIdempotencyKey attaches a caller supplied idempotency key to the
+// params carried by ctx; it takes precedence over generated keys.
+func ContextWithIdempotencyKey(ctx context.Context, key string) context.Context {
+	params := ParamsFromContext(ctx)
+	params.IdempotencyKey = key
+
+	return ContextWithParams(ctx, params)
+}
+
+func NewIdempotencyKey() string {
+	return rand.Text()
+}
+
+// withIdempotencyKey resolves the key for a single logical call and stashes it
+// in the context so each attempt built by NewRequest sends the same value.
+func (b *ApiBackend) withIdempotencyKey(ctx context.Context, params RequestContainer) context.Context {
+	if params.RequestParams().IdempotencyKey != "" || !b.c.IdempotencyKeys {
+		return ctx
+	}
+
+	// reads only need a key when they have side effects
+	switch params.Method() {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		if op, _ := OperationFor(params); !op.Unsafe {
+			return ctx
+		}
+	}
+
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, NewIdempotencyKey())
+}
+
+func idempotencyKeyFromContext(ctx context.Context, params Params) string {
+	if params.IdempotencyKey != "" {
+		return params.IdempotencyKey
+	}
+
+	key, _ := ctx.Value(idempotencyKeyContextKey{}).(string)
+
+	return key
+}
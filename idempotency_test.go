@@ -0,0 +1,114 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestIdempotencyKeyReusedAcrossRetries(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader))
+		n := len(keys)
+		mu.Unlock()
+
+		if n < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		writeTestJSON(w, http.StatusOK, map[string]any{"id": testUserID})
+	}, WithIdempotencyKeys())
+
+	if _, err := c.UserCreate(context.Background(), &UserCreateInput{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 3 || keys[0] == "" || keys[0] != keys[1] || keys[1] != keys[2] {
+		t.Fatalf("keys = %q, want one key for every attempt", keys)
+	}
+
+	if _, err := c.UserCreate(context.Background(), &UserCreateInput{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys[3] == "" || keys[3] == keys[0] {
+		t.Fatalf("second call key = %q, want a new key", keys[3])
+	}
+}
+
+func TestIdempotencyKeyFromContext(t *testing.T) {
+	var key string
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get(IdempotencyKeyHeader)
+		writeTestJSON(w, http.StatusOK, map[string]any{"id": testUserID})
+	}, WithIdempotencyKeys())
+
+	ctx := ContextWithIdempotencyKey(context.Background(), "order-1234")
+
+	if _, err := c.UserCreate(ctx, &UserCreateInput{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if key != "order-1234" {
+		t.Fatalf("key = %q", key)
+	}
+}
+
+func TestIdempotencyKeyNotSentOnReads(t *testing.T) {
+	var key string
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get(IdempotencyKeyHeader)
+		writeTestJSON(w, http.StatusOK, map[string]any{"id": testUserID})
+	}, WithIdempotencyKeys())
+
+	if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if key != "" {
+		t.Fatalf("GET sent key %q", key)
+	}
+}
+
+func TestIdempotencyKeysDisabled(t *testing.T) {
+	var key string
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get(IdempotencyKeyHeader)
+		writeTestJSON(w, http.StatusOK, map[string]any{"id": testUserID})
+	})
+
+	if _, err := c.UserCreate(context.Background(), &UserCreateInput{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if key != "" {
+		t.Fatalf("key = %q without WithIdempotencyKeys", key)
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+type (
+	// userTokenCache keeps the token sources of ActAsUser, least recently
+	// used first out.
+	userTokenCache struct {
+		mu      sync.Mutex
+		size    int
+		sources map[string]*list.Element
+		lru     *list.List
+	}
+
+	userTokenEntry struct {
+		key string
+		ts  *tokenSource
+	}
+
+	// mintContext carries the cancellation and tracing of a call into the
+	// mint of its user token.
+	mintContext struct {
+		context.Context
+	}
+)
+
+const (
+	maxUserTokens = 1000
+)
+
+// ContextWithToken makes requests made with ctx use token instead of the
+// client credentials.
+func ContextWithToken(ctx context.Context, token string) context.Context {
+	params := ParamsFromContext(ctx)
+	params.AccessToken = token
+
+	return ContextWithParams(ctx, params)
+}
+
+// ContextWithTokenSource makes requests made with ctx use tokens from ts
+// instead of the client credentials.
+func ContextWithTokenSource(ctx context.Context, ts oauth2.TokenSource) context.Context {
+	params := ParamsFromContext(ctx)
+	params.TokenSource = ts
+
+	return ContextWithParams(ctx, params)
+}
+
+// ActAsUser returns a context whose requests act on behalf of params.UserID.
+// The user token is minted with AccessTokenCreate on first use and cached on
+// the client, per user, params and instance, until it expires or is
+// rejected. The mint uses the client's own credentials and only the instance
+// of ctx; the cache keeps the most recently used maxUserTokens users.
+func (c *Client) ActAsUser(ctx context.Context, params *AccessTokenCreateInput) (context.Context, error) {
+	if params == nil || params.UserID == nil {
+		return nil, errors.New("user_id is required")
+	}
+
+	key, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	instance := ParamsFromContext(ctx).Instance
+
+	if instance != nil {
+		key = fmt.Appendf(key, "\x00%s", *instance)
+	}
+
+	var ts *tokenSource
+	if c.userTokens != nil {
+		ts = c.userTokens.get(string(key), func() *tokenSource {
+			return c.userTokenSource(*params, instance)
+		})
+	} else {
+		// clients not built with NewClient have no cache
+		ts = c.userTokenSource(*params, instance)
+	}
+
+	return ContextWithTokenSource(ctx, ts), nil
+}
+
+func (c *Client) userTokenSource(params AccessTokenCreateInput, instance *string) *tokenSource {
+	return &tokenSource{
+		fetch: func(ctx context.Context, _ *oauth2.Token, _ bool) (*oauth2.Token, error) {
+			ctx = ContextWithParams(mintContext{ctx}, Params{Instance: instance})
+
+			tok, err := c.AccessTokenCreate(ctx, &params)
+			if err != nil {
+				return nil, err
+			}
+
+			secret, expires, err := accessTokenSecret(tok)
+			if err != nil {
+				return nil, err
+			}
+
+			return &oauth2.Token{
+				AccessToken: secret,
+				TokenType:   "Bearer",
+				Expiry:      expires,
+			}, nil
+		},
+	}
+}
+
+func newUserTokenCache(size int) *userTokenCache {
+	return &userTokenCache{
+		size:    size,
+		sources: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// get returns the source cached for key, creating it with fn and evicting
+// the least recently used source beyond the cache size.
+func (c *userTokenCache) get(key string, fn func() *tokenSource) *tokenSource {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.sources[key]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*userTokenEntry).ts
+	}
+
+	ts := fn()
+	c.sources[key] = c.lru.PushFront(&userTokenEntry{key: key, ts: ts})
+
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.sources, oldest.Value.(*userTokenEntry).key)
+	}
+
+	return ts
+}
+
+// Value hides the values of the call that triggered a mint, so its
+// idempotency key, response capture and hooks don't apply to the mint.
+func (c mintContext) Value(key any) any {
+	switch key.(type) {
+	case ClientParamsKey, idempotencyKeyContextKey, responseCaptureKey, httpHooksKey, uploadOptionsKey, endpointContextKey:
+		return nil
+	}
+
+	return c.Context.Value(key)
+}
@@ -0,0 +1,215 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// userTokenServer mints user-1, user-2, ... for requests authorized with the
+// client token and rejects user tokens listed in expired
+type userTokenServer struct {
+	mu      sync.Mutex
+	minted  int
+	expired map[string]bool
+	auths   []string
+	mints   []*http.Request
+}
+
+func (s *userTokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	auth := bearerToken(r.Header.Get("Authorization"))
+
+	if strings.HasSuffix(r.URL.Path, "/tokens") {
+		if auth != "test-token" {
+			writeTestJSON(w, http.StatusUnauthorized, map[string]any{"code": "unauthorized"})
+			return
+		}
+		s.minted++
+		s.mints = append(s.mints, r)
+		writeTestJSON(w, http.StatusOK, map[string]any{
+			"id":    fmt.Sprintf("t%d", s.minted),
+			"token": fmt.Sprintf("user-%d", s.minted),
+		})
+		return
+	}
+
+	s.auths = append(s.auths, auth)
+	if s.expired[auth] {
+		writeTestJSON(w, http.StatusUnauthorized, map[string]any{"code": "unauthorized"})
+		return
+	}
+	writeTestJSON(w, http.StatusOK, map[string]any{"id": testUserID})
+}
+
+func TestContextWithTokenOverridesClient(t *testing.T) {
+	srv := &userTokenServer{}
+	c := newTestClient(t, srv.ServeHTTP)
+	params := &UserGetInput{UserID: testID(t, testUserID)}
+
+	if _, err := c.UserGet(ContextWithToken(context.Background(), "other"), params); err != nil {
+		t.Fatal(err)
+	}
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "from-source"})
+	if _, err := c.UserGet(ContextWithTokenSource(context.Background(), ts), params); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UserGet(context.Background(), params); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"other", "from-source", "test-token"}
+	if fmt.Sprint(srv.auths) != fmt.Sprint(want) {
+		t.Fatalf("auths = %v, want %v", srv.auths, want)
+	}
+}
+
+func TestActAsUserCachesToken(t *testing.T) {
+	srv := &userTokenServer{}
+	c := newTestClient(t, srv.ServeHTTP)
+	params := &UserGetInput{UserID: testID(t, testUserID)}
+
+	for range 3 {
+		ctx, err := c.ActAsUser(context.Background(), &AccessTokenCreateInput{UserID: testID(t, testUserID)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.UserGet(ctx, params); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if srv.minted != 1 {
+		t.Errorf("minted %d tokens, want 1", srv.minted)
+	}
+	if fmt.Sprint(srv.auths) != "[user-1 user-1 user-1]" {
+		t.Errorf("auths = %v", srv.auths)
+	}
+}
+
+func TestActAsUserRemintsRejectedToken(t *testing.T) {
+	srv := &userTokenServer{expired: map[string]bool{"user-1": true}}
+	c := newTestClient(t, srv.ServeHTTP)
+
+	ctx, err := c.ActAsUser(context.Background(), &AccessTokenCreateInput{UserID: testID(t, testUserID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UserGet(ctx, &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if srv.minted != 2 {
+		t.Errorf("minted %d tokens, want 2", srv.minted)
+	}
+	if fmt.Sprint(srv.auths) != "[user-1 user-2]" {
+		t.Errorf("auths = %v", srv.auths)
+	}
+}
+
+func TestActAsUserRequiresUser(t *testing.T) {
+	c := New(WithToken("test-token"))
+
+	if _, err := c.ActAsUser(context.Background(), &AccessTokenCreateInput{ApplicationID: testID(t, testAppID)}); err == nil {
+		t.Error("expected an error without a user id")
+	}
+}
+
+func TestActAsUserMintsWithoutCallerParams(t *testing.T) {
+	srv := &userTokenServer{}
+	c := newTestClient(t, srv.ServeHTTP, WithIdempotencyKeys())
+
+	instance := "instance-a"
+	ctx := ContextWithParams(context.Background(), Params{
+		Instance:       &instance,
+		IdempotencyKey: "caller-key",
+		Query:          url.Values{"caller": {"1"}},
+	})
+
+	ctx, err := c.ActAsUser(ctx, &AccessTokenCreateInput{UserID: testID(t, testUserID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UserUpdate(ctx, &UserUpdateInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(srv.mints) != 1 {
+		t.Fatalf("minted %d tokens, want 1", len(srv.mints))
+	}
+
+	mint := srv.mints[0]
+	if got := mint.Header.Get("Atomic-Instance"); got != instance {
+		t.Errorf("mint instance = %q, want %q", got, instance)
+	}
+	if got := mint.Header.Get(IdempotencyKeyHeader); got == "caller-key" {
+		t.Error("the mint reused the caller's idempotency key")
+	}
+	if mint.URL.Query().Has("caller") {
+		t.Errorf("the mint carried the caller's query: %s", mint.URL.RawQuery)
+	}
+}
+
+func TestActAsUserCachesPerInstance(t *testing.T) {
+	srv := &userTokenServer{}
+	c := newTestClient(t, srv.ServeHTTP)
+
+	for _, instance := range []string{"a", "b", "a"} {
+		ctx := ContextWithParams(context.Background(), Params{Instance: &instance})
+
+		ctx, err := c.ActAsUser(ctx, &AccessTokenCreateInput{UserID: testID(t, testUserID)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.UserGet(ctx, &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if srv.minted != 2 {
+		t.Errorf("minted %d tokens, want one per instance", srv.minted)
+	}
+	if fmt.Sprint(srv.auths) != "[user-1 user-2 user-1]" {
+		t.Errorf("auths = %v", srv.auths)
+	}
+}
+
+func TestActAsUserCacheIsBounded(t *testing.T) {
+	c := New(WithToken("test-token"))
+	c.userTokens = newUserTokenCache(2)
+
+	for _, id := range []string{testUserID, testAppID, oldTokenID} {
+		if _, err := c.ActAsUser(context.Background(), &AccessTokenCreateInput{UserID: testID(t, id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := c.userTokens.lru.Len(); n != 2 || len(c.userTokens.sources) != 2 {
+		t.Errorf("cached %d sources, want 2", n)
+	}
+}
@@ -20,6 +20,7 @@ package atomic
 import (
 	"context"
 	"fmt"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -86,6 +87,12 @@ func (c *Client) InstanceList(ctx context.Context, params *InstanceListInput) ([
 	return resp.Value(), nil
 }
 
+func (c *Client) InstanceListAll(ctx context.Context, params *InstanceListInput, opts ...PageOption) iter.Seq2[*Instance, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*Instance, error) {
+		return c.InstanceList(ctx, params)
+	})
+}
+
 func (c *Client) InstanceUpdate(ctx context.Context, params *InstanceUpdateInput) (*Instance, error) {
 	var resp ResponseProxy[Instance]
 
@@ -17,21 +17,274 @@
 
 package atomic
 
+import (
+	"context"
+	"iter"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
 type (
+	// Iter steps through the items returned by a NextFunc.
+	//
+	// Deprecated: use the *ListAll iterators.
 	Iter[P any, T any] struct {
 		cur    T
-		list   []T
 		params P
 		next   NextFunc[P, T]
+		pull   func() (*T, error, bool)
+		stop   func()
 	}
 
+	// NextFunc returns the next item for params, or false when there are no
+	// more.
+	//
+	// Deprecated: use the *ListAll iterators.
 	NextFunc[P any, T any] func(P) (T, bool)
+
+	// PageOption configures the *ListAll iterators.
+	PageOption func(*pageConfig)
+
+	pageConfig struct {
+		pageSize int64
+		maxItems int64
+		prefetch bool
+	}
+
+	page[T any] struct {
+		items []*T
+		err   error
+	}
+
+	// window is the limit and offset of the list params under the keys their
+	// query encoding uses.
+	window struct {
+		limitKey  string
+		offsetKey string
+		limit     int64
+		offset    int64
+	}
 )
 
+const (
+	DefaultPageSize = 100
+)
+
+// NewIter returns an Iter over the items of n.
+//
+// Deprecated: use the *ListAll iterators.
 func NewIter[P any, T any](params P, n NextFunc[P, T]) *Iter[P, T] {
 	return &Iter[P, T]{
-		list:   make([]T, 0),
 		params: params,
 		next:   n,
 	}
 }
+
+// Next advances to the next item, reporting whether there is one.
+func (i *Iter[P, T]) Next() bool {
+	if i.pull == nil {
+		// every item is a page of its own
+		list := func(context.Context) ([]*T, error) {
+			v, ok := i.next(i.params)
+			if !ok {
+				return nil, nil
+			}
+			return []*T{&v}, nil
+		}
+
+		i.pull, i.stop = iter.Pull2(paginate(context.Background(), nil, []PageOption{WithPageSize(1)}, list))
+	}
+
+	v, _, ok := i.pull()
+	if !ok {
+		i.Stop()
+		return false
+	}
+
+	i.cur = *v
+
+	return true
+}
+
+// Current returns the item Next advanced to.
+func (i *Iter[P, T]) Current() T {
+	return i.cur
+}
+
+// Stop releases the iteration before it is exhausted.
+func (i *Iter[P, T]) Stop() {
+	if i.stop != nil {
+		i.stop()
+	}
+}
+
+// WithPageSize sets the number of items requested per page; by default the
+// limit of the list params is used, or DefaultPageSize when unset.
+func WithPageSize(n int64) PageOption {
+	return func(c *pageConfig) {
+		c.pageSize = n
+	}
+}
+
+// WithMaxItems stops the iteration after n items.
+func WithMaxItems(n int64) PageOption {
+	return func(c *pageConfig) {
+		c.maxItems = n
+	}
+}
+
+// WithPrefetch fetches the next page in the background while the current one
+// is being consumed.
+func WithPrefetch() PageOption {
+	return func(c *pageConfig) {
+		c.prefetch = true
+	}
+}
+
+// paginate walks a list endpoint lazily, one page at a time, by overriding the
+// limit and offset query params of each call to list. Iteration stops at the
+// first empty page, after the max items cap, or when ctx is done; errors are
+// yielded once and end the iteration. Short pages don't end the iteration,
+// since servers may cap the page below the requested size, and pages longer
+// than requested are cut to the requested size.
+func paginate[T any](ctx context.Context, params any, opts []PageOption, list func(context.Context) ([]*T, error)) iter.Seq2[*T, error] {
+	cfg := pageConfig{}
+
+	// start from the window of the list params, if any
+	win := listWindow(params)
+	if win.limit > 0 {
+		cfg.pageSize = win.limit
+	}
+
+	offset := win.offset
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.pageSize <= 0 {
+		cfg.pageSize = DefaultPageSize
+	}
+
+	return func(yield func(*T, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		fetch := func(offset, count int64) page[T] {
+			size := cfg.pageSize
+			if cfg.maxItems > 0 {
+				size = min(size, cfg.maxItems-count)
+			}
+
+			p := ParamsFromContext(ctx)
+
+			q := url.Values{}
+			for k, v := range p.Query {
+				q[k] = v
+			}
+			q[win.limitKey] = []string{strconv.FormatInt(size, 10)}
+			q[win.offsetKey] = []string{strconv.FormatInt(offset, 10)}
+			p.Query = q
+
+			items, err := list(ContextWithParams(ctx, p))
+
+			return page[T]{items: items, err: err}
+		}
+
+		prefetch := func(offset, count int64) <-chan page[T] {
+			// buffered so the goroutine never blocks if iteration stops early
+			ch := make(chan page[T], 1)
+			go func() {
+				ch <- fetch(offset, count)
+			}()
+			return ch
+		}
+
+		var (
+			count   int64
+			pending <-chan page[T]
+		)
+
+		for {
+			var pg page[T]
+
+			if pending != nil {
+				select {
+				case pg = <-pending:
+				case <-ctx.Done():
+					yield(nil, ctx.Err())
+					return
+				}
+				pending = nil
+			} else {
+				if err := ctx.Err(); err != nil {
+					yield(nil, err)
+					return
+				}
+				pg = fetch(offset, count)
+			}
+
+			if pg.err != nil {
+				yield(nil, pg.err)
+				return
+			}
+
+			requested := cfg.pageSize
+			if cfg.maxItems > 0 {
+				requested = min(requested, cfg.maxItems-count)
+			}
+
+			if int64(len(pg.items)) > requested {
+				pg.items = pg.items[:requested]
+			}
+
+			offset += int64(len(pg.items))
+
+			done := len(pg.items) == 0 ||
+				(cfg.maxItems > 0 && count+int64(len(pg.items)) >= cfg.maxItems)
+
+			if !done && cfg.prefetch {
+				pending = prefetch(offset, count+int64(len(pg.items)))
+			}
+
+			for _, item := range pg.items {
+				if !yield(item, nil) {
+					return
+				}
+				count++
+			}
+
+			if done {
+				return
+			}
+		}
+	}
+}
+
+// listWindow reads the limit and offset already set on the list params from
+// the same query encoding the request sends, so the page overrides replace
+// those keys rather than adding differently cased ones.
+func listWindow(params any) window {
+	win := window{limitKey: "limit", offsetKey: "offset"}
+
+	for k, v := range queryValues(params) {
+		if len(v) == 0 {
+			continue
+		}
+
+		n, err := strconv.ParseInt(v[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case strings.EqualFold(k, "limit"):
+			win.limitKey, win.limit = k, n
+		case strings.EqualFold(k, "offset"):
+			win.offsetKey, win.offset = k, n
+		}
+	}
+
+	return win
+}
@@ -0,0 +1,261 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+type (
+	pageInput struct {
+		Limit  *int64  `url:"limit,omitempty"`
+		Offset *int64  `url:"offset,omitempty"`
+		Filter *string `url:"filter,omitempty"`
+	}
+
+	// pageInputUpper encodes its window under differently cased keys
+	pageInputUpper struct {
+		Limit  *int64 `url:"Limit,omitempty"`
+		Offset *int64 `url:"Offset,omitempty"`
+	}
+
+	pageItem struct {
+		N int `json:"n"`
+	}
+)
+
+func (pageInput) Validate() error { return nil }
+
+func (pageInputUpper) Validate() error { return nil }
+
+// pageServer serves total items, honoring the window under the given keys;
+// extra items are appended to every page to mimic servers ignoring limit.
+func pageServer(t *testing.T, total, extra int, limitKey, offsetKey string) (*Client, func() []url.Values) {
+	t.Helper()
+
+	var (
+		mu      sync.Mutex
+		queries []url.Values
+	)
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		mu.Lock()
+		queries = append(queries, q)
+		mu.Unlock()
+
+		limit, _ := strconv.Atoi(q.Get(limitKey))
+		offset, _ := strconv.Atoi(q.Get(offsetKey))
+
+		out := []pageItem{}
+		for i := offset; i < min(offset+limit+extra, total); i++ {
+			out = append(out, pageItem{N: i})
+		}
+
+		writeTestJSON(w, http.StatusOK, out)
+	})
+
+	return c, func() []url.Values {
+		mu.Lock()
+		defer mu.Unlock()
+		return queries
+	}
+}
+
+func listPage[P validation.Validatable](c *Client, params P) func(context.Context) ([]*pageItem, error) {
+	return func(ctx context.Context) ([]*pageItem, error) {
+		var resp ResponseProxy[[]*pageItem]
+
+		if err := c.Backend.ExecContext(ctx, NewRequest(ctx, "/items", params).Get(), &resp); err != nil {
+			return nil, err
+		}
+
+		return resp.Value(), nil
+	}
+}
+
+func collect(t *testing.T, seq func(func(*pageItem, error) bool)) []int {
+	t.Helper()
+
+	var out []int
+	for item, err := range seq {
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, item.N)
+	}
+
+	return out
+}
+
+func TestPaginateWindow(t *testing.T) {
+	c, queries := pageServer(t, 25, 0, "limit", "offset")
+
+	limit, offset, filter := int64(10), int64(3), "active"
+	params := &pageInput{Limit: &limit, Offset: &offset, Filter: &filter}
+
+	got := collect(t, paginate(context.Background(), params, []PageOption{WithPrefetch()}, listPage(c, params)))
+
+	if len(got) != 22 || got[0] != 3 || got[21] != 24 {
+		t.Fatalf("items = %v", got)
+	}
+
+	// the last page is short, the empty one after it ends the iteration
+	offsets := []string{"3", "13", "23", "25"}
+	if q := queries(); len(q) != len(offsets) {
+		t.Fatalf("queries = %v", q)
+	}
+
+	for i, q := range queries() {
+		if len(q["limit"]) != 1 || q.Get("limit") != "10" || q.Get("offset") != offsets[i] || q.Get("filter") != "active" {
+			t.Errorf("page %d query = %v", i, q)
+		}
+	}
+}
+
+func TestPaginateWindowKeys(t *testing.T) {
+	c, queries := pageServer(t, 7, 0, "Limit", "Offset")
+
+	limit, offset := int64(3), int64(0)
+	params := &pageInputUpper{Limit: &limit, Offset: &offset}
+
+	got := collect(t, paginate(context.Background(), params, nil, listPage(c, params)))
+	if len(got) != 7 {
+		t.Fatalf("items = %v", got)
+	}
+
+	offsets := []string{"0", "3", "6", "7"}
+	for i, q := range queries() {
+		if _, ok := q["limit"]; ok || len(q["Limit"]) != 1 || i >= len(offsets) || q.Get("Offset") != offsets[i] {
+			t.Errorf("page %d query = %v", i, q)
+		}
+	}
+}
+
+func TestPaginateMaxItems(t *testing.T) {
+	// the server returns more than asked for on every page
+	c, queries := pageServer(t, 100, 4, "limit", "offset")
+
+	params := &pageInput{}
+
+	got := collect(t, paginate(context.Background(), params, []PageOption{WithPageSize(3), WithMaxItems(8)}, listPage(c, params)))
+
+	want := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	if len(got) != len(want) {
+		t.Fatalf("items = %v", got)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("items = %v", got)
+		}
+	}
+
+	if q := queries(); len(q) != 3 || q[2].Get("limit") != "2" {
+		t.Errorf("queries = %v", q)
+	}
+}
+
+func TestPaginateError(t *testing.T) {
+	calls := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls++; calls == 1 {
+			writeTestJSON(w, http.StatusOK, []pageItem{{N: 0}, {N: 1}})
+			return
+		}
+		writeTestJSON(w, http.StatusNotFound, map[string]any{"code": "not_found"})
+	})
+
+	params := &pageInput{}
+
+	var (
+		n    int
+		errs []error
+	)
+
+	for _, err := range paginate(context.Background(), params, []PageOption{WithPageSize(2)}, listPage(c, params)) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n++
+	}
+
+	if n != 2 || len(errs) != 1 || !errors.Is(errs[0], ErrNotFound) {
+		t.Fatalf("n = %d, errs = %v", n, errs)
+	}
+}
+
+func TestPaginateServerCap(t *testing.T) {
+	// the server returns at most 4 items whatever the limit
+	var offsets []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		offsets = append(offsets, q.Get("offset"))
+
+		offset, _ := strconv.Atoi(q.Get("offset"))
+
+		out := []pageItem{}
+		for i := offset; i < min(offset+4, 10); i++ {
+			out = append(out, pageItem{N: i})
+		}
+
+		writeTestJSON(w, http.StatusOK, out)
+	})
+
+	params := &pageInput{}
+
+	got := collect(t, paginate(context.Background(), params, []PageOption{WithPageSize(100)}, listPage(c, params)))
+	if len(got) != 10 || got[9] != 9 {
+		t.Fatalf("items = %v", got)
+	}
+
+	if fmt.Sprint(offsets) != "[0 4 8 10]" {
+		t.Errorf("offsets = %v", offsets)
+	}
+}
+
+func TestIter(t *testing.T) {
+	n := 0
+	it := NewIter(3, func(limit int) (int, bool) {
+		if n >= limit {
+			return 0, false
+		}
+		n++
+		return n, true
+	})
+
+	var got []int
+	for it.Next() {
+		got = append(got, it.Current())
+	}
+
+	if fmt.Sprint(got) != "[1 2 3]" || it.Next() {
+		t.Errorf("items = %v", got)
+	}
+}
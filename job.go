@@ -20,6 +20,7 @@ package atomic
 import (
 	"context"
 	"fmt"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -99,6 +100,12 @@ func (c *Client) JobList(ctx context.Context, params *JobListInput) ([]*Job, err
 	return resp.Value(), nil
 }
 
+func (c *Client) JobListAll(ctx context.Context, params *JobListInput, opts ...PageOption) iter.Seq2[*Job, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*Job, error) {
+		return c.JobList(ctx, params)
+	})
+}
+
 func (c *Client) JobRestart(ctx context.Context, params *JobRestartInput) (*Job, error) {
 	var resp ResponseProxy[Job]
 
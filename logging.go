@@ -0,0 +1,263 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// LogOptions controls what WithLogger emits. Credentials (Authorization and
+	// cookie headers, the access token, client secrets and password/token
+	// fields) are always redacted; PIIFields adds JSON body and query keys
+	// whose values are masked as well.
+	LogOptions struct {
+		RequestLevel  slog.Level
+		ResponseLevel slog.Level
+		// ErrorLevel is used for transport failures and responses >= 400
+		ErrorLevel slog.Level
+		// Bodies includes the redacted JSON request and response bodies
+		Bodies bool
+		// PIIFields are matched case-insensitively against JSON keys and query
+		// parameters; nil selects DefaultPIIFields
+		PIIFields []string
+	}
+)
+
+const (
+	redacted = "[REDACTED]"
+)
+
+var (
+	DefaultPIIFields = []string{"email", "phone", "phone_number", "to", "cc", "bcc"}
+
+	sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+	sensitiveFields = []string{
+		"password", "new_password", "secret", "client_secret",
+		"token", "access_token", "refresh_token", "id_token",
+	}
+)
+
+func DefaultLogOptions() LogOptions {
+	return LogOptions{
+		RequestLevel:  slog.LevelDebug,
+		ResponseLevel: slog.LevelDebug,
+		ErrorLevel:    slog.LevelWarn,
+	}
+}
+
+// WithLogger logs a line for every request attempt and its response.
+func WithLogger(logger *slog.Logger) ApiOption {
+	return func(c *ApiConfig) {
+		c.Logger = logger
+	}
+}
+
+func WithLogOptions(opts LogOptions) ApiOption {
+	return func(c *ApiConfig) {
+		c.LogOptions = opts
+	}
+}
+
+func (b *ApiBackend) logRequest(ctx context.Context, req *http.Request, attempt int) {
+	opts := b.c.LogOptions
+
+	if b.c.Logger == nil || !b.c.Logger.Enabled(ctx, opts.RequestLevel) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", req.Method),
+		slog.String("url", b.redactURL(req.URL)),
+		slog.Int("attempt", attempt),
+		slog.Any("headers", b.redactHeaders(req.Header)),
+	}
+
+	if opts.Bodies && req.GetBody != nil && isJSON(req.Header.Get("Content-Type")) {
+		if rc, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			attrs = append(attrs, slog.String("body", b.redactBody(data)))
+		}
+	}
+
+	b.c.Logger.LogAttrs(ctx, opts.RequestLevel, "atomic request", attrs...)
+}
+
+func (b *ApiBackend) logResponse(ctx context.Context, req *http.Request, resp *http.Response, body []byte, err error, attempt int, elapsed time.Duration) {
+	opts := b.c.LogOptions
+
+	level := opts.ResponseLevel
+	if err != nil || resp.StatusCode >= 400 {
+		level = opts.ErrorLevel
+	}
+
+	if b.c.Logger == nil || !b.c.Logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", req.Method),
+		slog.String("url", b.redactURL(req.URL)),
+		slog.Int("attempt", attempt),
+		slog.Duration("elapsed", elapsed),
+	}
+
+	if err != nil {
+		attrs = append(attrs, slog.String("error", b.redactString(err.Error())))
+		b.c.Logger.LogAttrs(ctx, level, "atomic request failed", attrs...)
+		return
+	}
+
+	attrs = append(attrs,
+		slog.Int("status", resp.StatusCode),
+		slog.String("request_id", resp.Header.Get(RequestIDHeader)),
+		slog.Any("headers", b.redactHeaders(resp.Header)))
+
+	if opts.Bodies && len(body) > 0 {
+		if isJSON(resp.Header.Get("Content-Type")) || json.Valid(body) {
+			attrs = append(attrs, slog.String("body", b.redactBody(body)))
+		} else {
+			attrs = append(attrs, slog.String("body", "["+strconv.Itoa(len(body))+" bytes]"))
+		}
+	}
+
+	b.c.Logger.LogAttrs(ctx, level, "atomic response", attrs...)
+}
+
+func (b *ApiBackend) redactHeaders(h http.Header) http.Header {
+	out := h.Clone()
+
+	for _, k := range sensitiveHeaders {
+		if out.Get(k) != "" {
+			out.Set(k, redacted)
+		}
+	}
+
+	for _, v := range out {
+		for i := range v {
+			v[i] = b.redactString(v[i])
+		}
+	}
+
+	return out
+}
+
+func (b *ApiBackend) redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return b.redactString(u.String())
+	}
+
+	clone := *u
+
+	q := clone.Query()
+	for k := range q {
+		if b.isRedactedField(k) {
+			q.Set(k, redacted)
+		}
+	}
+	clone.RawQuery = q.Encode()
+
+	return b.redactString(clone.String())
+}
+
+// redactBody masks sensitive and PII keys anywhere in a JSON document; bodies
+// that aren't JSON are reduced to their size.
+func (b *ApiBackend) redactBody(data []byte) string {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "[" + strconv.Itoa(len(data)) + " bytes]"
+	}
+
+	out, err := json.Marshal(b.redactValue(v))
+	if err != nil {
+		return redacted
+	}
+
+	return b.redactString(string(out))
+}
+
+func (b *ApiBackend) redactValue(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, item := range val {
+			if b.isRedactedField(k) {
+				val[k] = redacted
+			} else {
+				val[k] = b.redactValue(item)
+			}
+		}
+	case []any:
+		for i, item := range val {
+			val[i] = b.redactValue(item)
+		}
+	}
+
+	return v
+}
+
+// redactString scrubs known secret values wherever they appear.
+func (b *ApiBackend) redactString(s string) string {
+	for _, secret := range b.c.secrets() {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+	}
+
+	return s
+}
+
+func (b *ApiBackend) isRedactedField(key string) bool {
+	pii := b.c.LogOptions.PIIFields
+	if pii == nil {
+		pii = DefaultPIIFields
+	}
+
+	for _, list := range [][]string{sensitiveFields, pii} {
+		for _, f := range list {
+			if strings.EqualFold(f, key) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (c ApiConfig) secrets() []string {
+	rval := append([]string{c.AccessToken}, c.clientSecrets...)
+
+	if tok := c.tokens.current(); tok != nil {
+		rval = append(rval, tok.AccessToken, tok.RefreshToken)
+	}
+
+	return rval
+}
+
+func isJSON(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json")
+}
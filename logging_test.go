@@ -0,0 +1,113 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newLogClient(t *testing.T, h http.HandlerFunc, opts LogOptions, extra ...ApiOption) (*Client, *bytes.Buffer) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	return newTestClient(t, h, append([]ApiOption{WithLogger(logger), WithLogOptions(opts)}, extra...)...), &buf
+}
+
+func TestLoggingRedactsSecretsAndPII(t *testing.T) {
+	c, buf := newLogClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]any{
+			"id":    testUserID,
+			"email": "jane@example.com",
+			"links": []any{map[string]any{"phone": "+15550100"}},
+			"note":  "issued to test-token",
+		})
+	}, LogOptions{Bodies: true})
+
+	email, password := "jane@example.com", "hunter2"
+
+	if _, err := c.UserCreate(context.Background(), &UserCreateInput{Email: &email, Password: &password}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+
+	for _, leak := range []string{"hunter2", "test-token", "jane@example.com", "+15550100"} {
+		if strings.Contains(out, leak) {
+			t.Errorf("log leaks %q:\n%s", leak, out)
+		}
+	}
+
+	if !strings.Contains(out, "atomic request") || !strings.Contains(out, "atomic response") {
+		t.Errorf("missing request or response line:\n%s", out)
+	}
+}
+
+func TestLoggingRedactsClientSecret(t *testing.T) {
+	c, buf := newLogClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth/token" {
+			writeTestJSON(w, http.StatusOK, map[string]any{"access_token": "cc-token", "token_type": "bearer", "expires_in": 3600})
+			return
+		}
+		writeTestJSON(w, http.StatusBadRequest, map[string]any{"message": "bad secret s3cr3t"})
+	}, LogOptions{Bodies: true}, WithClientCredentials("client", "s3cr3t"))
+
+	if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	out := buf.String()
+
+	for _, leak := range []string{"s3cr3t", "cc-token"} {
+		if strings.Contains(out, leak) {
+			t.Errorf("log leaks %q:\n%s", leak, out)
+		}
+	}
+}
+
+func TestLoggingLevels(t *testing.T) {
+	c, buf := newLogClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusNotFound, map[string]any{"code": "not_found"})
+	}, LogOptions{
+		RequestLevel:  slog.LevelDebug - 4,
+		ResponseLevel: slog.LevelDebug,
+		ErrorLevel:    slog.LevelError,
+	})
+
+	c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)})
+
+	out := buf.String()
+
+	if strings.Contains(out, `msg="atomic request"`) {
+		t.Errorf("request logged below the handler level:\n%s", out)
+	}
+
+	if !strings.Contains(out, "level=ERROR") || !strings.Contains(out, "status=404") {
+		t.Errorf("error response not logged at ErrorLevel:\n%s", out)
+	}
+
+	if strings.Contains(out, "body=") {
+		t.Errorf("body logged without Bodies:\n%s", out)
+	}
+}
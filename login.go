@@ -0,0 +1,267 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+type (
+	AuthCodeConfig struct {
+		// Host is the API host or base URL, DefaultAPIHost if empty
+		Host         string
+		ClientID     string
+		ClientSecret string
+		// RedirectURL must be registered with the application; Loopback picks
+		// a free 127.0.0.1 port when it is empty
+		RedirectURL string
+		Scopes      []string
+		HTTPClient  *http.Client
+	}
+
+	// AuthCodeLogin is a single authorization code login with PKCE. Web apps
+	// should keep State, Nonce and Verifier in the user's session between the
+	// redirect and the callback.
+	AuthCodeLogin struct {
+		Config   oauth2.Config
+		State    string
+		Nonce    string
+		Verifier string
+		client   *http.Client
+	}
+)
+
+var (
+	ErrStateMismatch = errors.New("oauth state mismatch")
+	ErrNonceMismatch = errors.New("oauth nonce mismatch")
+)
+
+const loopbackDone = `<!DOCTYPE html><html><body><p>Login complete, you can close this window.</p></body></html>`
+
+// Endpoint returns the OAuth endpoints of an API host or base URL.
+func Endpoint(host string) oauth2.Endpoint {
+	base, err := baseURL(host)
+	if err != nil {
+		base = &url.URL{Scheme: "https", Host: host}
+	}
+
+	return oauth2.Endpoint{
+		AuthURL:       base.JoinPath("oauth", "authorize").String(),
+		TokenURL:      base.JoinPath("oauth", "token").String(),
+		DeviceAuthURL: base.JoinPath("oauth", "device", "code").String(),
+	}
+}
+
+func NewAuthCodeLogin(cfg AuthCodeConfig) *AuthCodeLogin {
+	if cfg.Host == "" {
+		cfg.Host = DefaultAPIHost
+	}
+
+	return &AuthCodeLogin{
+		Config: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     Endpoint(cfg.Host),
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       cfg.Scopes,
+		},
+		State:    rand.Text(),
+		Nonce:    rand.Text(),
+		Verifier: oauth2.GenerateVerifier(),
+		client:   cfg.HTTPClient,
+	}
+}
+
+// AuthCodeURL returns the URL to send the user to.
+func (l *AuthCodeLogin) AuthCodeURL(opts ...oauth2.AuthCodeOption) string {
+	opts = append([]oauth2.AuthCodeOption{
+		oauth2.S256ChallengeOption(l.Verifier),
+		oauth2.SetAuthURLParam("nonce", l.Nonce),
+	}, opts...)
+
+	return l.Config.AuthCodeURL(l.State, opts...)
+}
+
+// HandleCallback validates the redirect request and exchanges its code. The
+// state is checked first, so a forged callback can't report an error either.
+func (l *AuthCodeLogin) HandleCallback(ctx context.Context, r *http.Request) (*oauth2.Token, error) {
+	q := r.URL.Query()
+
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(l.State)) != 1 {
+		return nil, ErrStateMismatch
+	}
+
+	if code := q.Get("error"); code != "" {
+		return nil, Error{
+			Code:    code,
+			Message: q.Get("error_description"),
+		}
+	}
+
+	code := q.Get("code")
+	if code == "" {
+		return nil, errors.New("authorization code missing from callback")
+	}
+
+	return l.Exchange(ctx, code)
+}
+
+// Exchange trades an authorization code for a token, checking the nonce of
+// the id token when the server returns one.
+func (l *AuthCodeLogin) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+	tok, err := l.Config.Exchange(withHTTPClient(ctx, l.client), code, oauth2.VerifierOption(l.Verifier))
+	if err != nil {
+		return nil, transportError(err)
+	}
+
+	if idToken, ok := tok.Extra("id_token").(string); ok && idToken != "" {
+		nonce, err := idTokenNonce(idToken)
+		if err != nil {
+			return nil, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(nonce), []byte(l.Nonce)) != 1 {
+			return nil, ErrNonceMismatch
+		}
+	}
+
+	return tok, nil
+}
+
+// TokenSource returns a source that refreshes tok with its refresh token when
+// it expires or is rejected, for use with WithTokenSource.
+func (l *AuthCodeLogin) TokenSource(ctx context.Context, tok *oauth2.Token) oauth2.TokenSource {
+	return newRefreshTokenSource(withHTTPClient(ctx, l.client), &l.Config, tok)
+}
+
+// Loopback runs the login for CLI and desktop apps: it serves the redirect on
+// a loopback address, passes the authorize URL to open (e.g. to launch a
+// browser) and waits for the callback or ctx to be done. Callbacks without the
+// login's state are answered with an error and otherwise ignored.
+func (l *AuthCodeLogin) Loopback(ctx context.Context, open func(authURL string) error) (*oauth2.Token, error) {
+	addr, path := "127.0.0.1:0", "/callback"
+
+	if l.Config.RedirectURL != "" {
+		u, err := url.Parse(l.Config.RedirectURL)
+		if err != nil {
+			return nil, err
+		}
+		addr, path = u.Host, cmp.Or(u.Path, "/")
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.Config.RedirectURL == "" {
+		l.Config.RedirectURL = fmt.Sprintf("http://%s%s", ln.Addr(), path)
+	}
+
+	type result struct {
+		tok *oauth2.Token
+		err error
+	}
+
+	done := make(chan result, 1)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != path {
+				http.NotFound(w, r)
+				return
+			}
+
+			tok, err := l.HandleCallback(ctx, r)
+			if errors.Is(err, ErrStateMismatch) {
+				http.Error(w, "login failed: "+err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				http.Error(w, "login failed: "+err.Error(), http.StatusBadRequest)
+			} else {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				fmt.Fprint(w, loopbackDone)
+			}
+
+			select {
+			case done <- result{tok, err}:
+			default:
+			}
+		}),
+	}
+
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	if err := open(l.AuthCodeURL()); err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-done:
+		return res.tok, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// withHTTPClient makes oauth2 requests use client when it is set.
+func withHTTPClient(ctx context.Context, client *http.Client) context.Context {
+	if client == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, oauth2.HTTPClient, client)
+}
+
+// idTokenNonce reads the nonce claim of an id token; the signature is not
+// checked here.
+func idTokenNonce(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed id token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed id token: %w", err)
+	}
+
+	var claims struct {
+		Nonce string `json:"nonce"`
+	}
+
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("malformed id token: %w", err)
+	}
+
+	return claims.Nonce, nil
+}
@@ -0,0 +1,161 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newAuthServer authorizes every login by redirecting back with code c1, and
+// exchanges the code after checking the PKCE verifier.
+func newAuthServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var challenge, nonce string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/authorize":
+			q := r.URL.Query()
+			challenge, nonce = q.Get("code_challenge"), q.Get("nonce")
+
+			u, _ := url.Parse(q.Get("redirect_uri"))
+			u.RawQuery = url.Values{"code": {"c1"}, "state": {q.Get("state")}}.Encode()
+			http.Redirect(w, r, u.String(), http.StatusFound)
+
+		case "/oauth/token":
+			r.ParseForm()
+
+			sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+			if base64.RawURLEncoding.EncodeToString(sum[:]) != challenge || r.Form.Get("code") != "c1" {
+				writeTestJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant"})
+				return
+			}
+
+			claims := base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, `{"nonce":%q}`, nonce))
+			writeTestJSON(w, http.StatusOK, map[string]any{
+				"access_token":  "a1",
+				"refresh_token": "r1",
+				"token_type":    "Bearer",
+				"expires_in":    60,
+				"id_token":      "x." + claims + ".y",
+			})
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// follow opens the authorize URL, first sending a forged callback.
+func follow(t *testing.T, forged func(redirect string) string) func(string) error {
+	return func(authURL string) error {
+		u, _ := url.Parse(authURL)
+		redirect := u.Query().Get("redirect_uri")
+
+		go func() {
+			if forged != nil {
+				resp, err := http.Get(forged(redirect))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+
+				if resp.StatusCode != http.StatusBadRequest {
+					t.Errorf("forged callback status = %d", resp.StatusCode)
+				}
+			}
+
+			resp, err := http.Get(authURL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+
+		return nil
+	}
+}
+
+func TestLoopbackIgnoresForgedCallback(t *testing.T) {
+	srv := newAuthServer(t)
+
+	l := NewAuthCodeLogin(AuthCodeConfig{Host: srv.URL, ClientID: "client"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tok, err := l.Loopback(ctx, follow(t, func(redirect string) string {
+		return redirect + "?error=access_denied&state=forged"
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tok.AccessToken != "a1" {
+		t.Errorf("token = %v", tok)
+	}
+}
+
+func TestLoopbackRootPath(t *testing.T) {
+	srv := newAuthServer(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	l := NewAuthCodeLogin(AuthCodeConfig{Host: srv.URL, ClientID: "client", RedirectURL: "http://" + addr})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := l.Loopback(ctx, follow(t, nil)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleCallbackChecksStateFirst(t *testing.T) {
+	l := NewAuthCodeLogin(AuthCodeConfig{ClientID: "client"})
+
+	r := httptest.NewRequest(http.MethodGet, "/callback?error=access_denied&state=forged", nil)
+	if _, err := l.HandleCallback(context.Background(), r); !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("forged error: err = %v", err)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/callback?error=access_denied&state="+url.QueryEscape(l.State), nil)
+
+	var e Error
+	if _, err := l.HandleCallback(context.Background(), r); !errors.As(err, &e) || e.Code != "access_denied" {
+		t.Errorf("error: err = %v", err)
+	}
+}
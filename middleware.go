@@ -0,0 +1,209 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+)
+
+type (
+	// Middleware wraps a Backend to add behavior around every call, e.g.
+	// logging, metrics or auth tweaks. It sees the RequestContainer and the
+	// decoded Responder directly; the HTTP exchange made by ApiBackend is
+	// reachable through ContextWithRequestHook and ContextWithResponseHook.
+	Middleware func(next Backend) Backend
+
+	// BackendFunc adapts a function to the Backend interface.
+	BackendFunc func(ctx context.Context, params RequestContainer, result Responder) error
+
+	// RequestHook may inspect or modify the request of every attempt before it
+	// is sent; returning an error aborts the call.
+	RequestHook func(req *http.Request) error
+
+	// ResponseHook observes the response of every attempt. The body has been
+	// buffered and can be read without affecting decoding.
+	ResponseHook func(req *http.Request, resp *http.Response)
+
+	httpHooks struct {
+		onRequest  []RequestHook
+		onResponse []ResponseHook
+	}
+
+	httpHooksKey struct{}
+)
+
+func (f BackendFunc) ExecContext(ctx context.Context, params RequestContainer, result Responder) error {
+	return f(ctx, params, result)
+}
+
+// Chain wraps b with the middlewares, the first one being the outermost.
+func Chain(b Backend, mw ...Middleware) Backend {
+	for i := len(mw) - 1; i >= 0; i-- {
+		b = mw[i](b)
+	}
+
+	return b
+}
+
+// WithMiddleware installs middlewares around the backend built by New; it may
+// be used more than once, later middlewares run inside earlier ones.
+func WithMiddleware(mw ...Middleware) ApiOption {
+	return func(c *ApiConfig) {
+		c.Middleware = append(c.Middleware, mw...)
+	}
+}
+
+func ContextWithRequestHook(ctx context.Context, hook RequestHook) context.Context {
+	h := hooksFromContext(ctx)
+
+	return context.WithValue(ctx, httpHooksKey{}, httpHooks{
+		onRequest:  append(h.onRequest[:len(h.onRequest):len(h.onRequest)], hook),
+		onResponse: h.onResponse,
+	})
+}
+
+func ContextWithResponseHook(ctx context.Context, hook ResponseHook) context.Context {
+	h := hooksFromContext(ctx)
+
+	return context.WithValue(ctx, httpHooksKey{}, httpHooks{
+		onRequest:  h.onRequest,
+		onResponse: append(h.onResponse[:len(h.onResponse):len(h.onResponse)], hook),
+	})
+}
+
+// HeaderMiddleware sets the headers on every request, replacing any value
+// already present.
+func HeaderMiddleware(headers http.Header) Middleware {
+	return func(next Backend) Backend {
+		return BackendFunc(func(ctx context.Context, params RequestContainer, result Responder) error {
+			ctx = ContextWithRequestHook(ctx, func(req *http.Request) error {
+				for k, v := range headers {
+					req.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+				}
+				return nil
+			})
+
+			return next.ExecContext(ctx, params, result)
+		})
+	}
+}
+
+// DumpMiddleware writes every request and response to w in wire format. The
+// Authorization and cookie headers are masked; request bodies are only included when they
+// can be re-read without affecting the call, and never for file uploads.
+func DumpMiddleware(w io.Writer, body bool) Middleware {
+	var mu sync.Mutex
+
+	return func(next Backend) Backend {
+		return BackendFunc(func(ctx context.Context, params RequestContainer, result Responder) error {
+			ctx = ContextWithRequestHook(ctx, func(req *http.Request) error {
+				out, err := dumpRequest(req, body)
+				if err != nil {
+					return err
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				fmt.Fprintf(w, "%s\n", out)
+
+				return nil
+			})
+
+			ctx = ContextWithResponseHook(ctx, func(req *http.Request, resp *http.Response) {
+				out, err := dumpResponse(resp, body)
+				if err != nil {
+					return
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				fmt.Fprintf(w, "%s\n", out)
+			})
+
+			return next.ExecContext(ctx, params, result)
+		})
+	}
+}
+
+func dumpRequest(req *http.Request, body bool) ([]byte, error) {
+	clone := req.Clone(req.Context())
+	maskHeaders(clone.Header)
+
+	if !body || req.GetBody == nil || strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/") {
+		return httputil.DumpRequestOut(clone, false)
+	}
+
+	rc, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	clone.Body = rc
+
+	return httputil.DumpRequestOut(clone, true)
+}
+
+func dumpResponse(resp *http.Response, body bool) ([]byte, error) {
+	clone := *resp
+	clone.Header = resp.Header.Clone()
+	maskHeaders(clone.Header)
+
+	out, err := httputil.DumpResponse(&clone, body)
+
+	// the dump replaces the body it read with a fresh reader
+	resp.Body = clone.Body
+
+	return out, err
+}
+
+// maskHeaders redacts the credential headers of h in place.
+func maskHeaders(h http.Header) {
+	for _, k := range sensitiveHeaders {
+		if _, ok := h[k]; ok {
+			h.Set(k, redacted)
+		}
+	}
+}
+
+func hooksFromContext(ctx context.Context) httpHooks {
+	h, _ := ctx.Value(httpHooksKey{}).(httpHooks)
+	return h
+}
+
+func (h httpHooks) request(req *http.Request) error {
+	for _, hook := range h.onRequest {
+		if err := hook(req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h httpHooks) response(req *http.Request, resp *http.Response) {
+	for _, hook := range h.onResponse {
+		hook(req, resp)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+
+	mw := func(name string) Middleware {
+		return func(next Backend) Backend {
+			return BackendFunc(func(ctx context.Context, params RequestContainer, result Responder) error {
+				order = append(order, name)
+				return next.ExecContext(ctx, params, result)
+			})
+		}
+	}
+
+	b := Chain(BackendFunc(func(ctx context.Context, params RequestContainer, result Responder) error {
+		order = append(order, "backend")
+		return nil
+	}), mw("outer"), mw("inner"))
+
+	if err := b.ExecContext(context.Background(), NewRequest(context.Background(), "/", &UserCreateInput{}), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(order, ",") != "outer,inner,backend" {
+		t.Fatalf("order = %v", order)
+	}
+}
+
+func TestHeaderMiddleware(t *testing.T) {
+	var got string
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Team")
+		writeTestJSON(w, http.StatusOK, map[string]any{"id": testUserID})
+	}, WithMiddleware(HeaderMiddleware(http.Header{"x-team": {"core"}})))
+
+	if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "core" {
+		t.Fatalf("X-Team = %q", got)
+	}
+}
+
+func TestDumpMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+
+	var cookie string
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		cookie = r.Header.Get("Cookie")
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret-session"})
+		writeTestJSON(w, http.StatusOK, map[string]any{"id": testUserID})
+	}, WithToken("secret-token"), WithMiddleware(
+		HeaderMiddleware(http.Header{"Cookie": {"session=secret-cookie"}}),
+		DumpMiddleware(&buf, true),
+	))
+
+	if _, err := c.UserCreate(context.Background(), &UserCreateInput{}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+
+	if strings.Contains(out, "secret-token") || !strings.Contains(out, "Authorization: [REDACTED]") {
+		t.Fatalf("dump leaks the token:\n%s", out)
+	}
+
+	for _, secret := range []string{"secret-cookie", "secret-session"} {
+		if strings.Contains(out, secret) {
+			t.Fatalf("dump leaks %s:\n%s", secret, out)
+		}
+	}
+
+	if !strings.Contains(out, "Cookie: [REDACTED]") || !strings.Contains(out, "Set-Cookie: [REDACTED]") {
+		t.Fatalf("dump misses the masked cookies:\n%s", out)
+	}
+
+	// masking the dump leaves the request alone
+	if cookie != "session=secret-cookie" {
+		t.Errorf("Cookie = %q", cookie)
+	}
+
+	if !strings.Contains(out, "POST /api/1.0.0/users") || !strings.Contains(out, testUserID) {
+		t.Fatalf("dump misses the exchange:\n%s", out)
+	}
+}
+
+func TestRequestHookAborts(t *testing.T) {
+	var calls int
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	})
+
+	errStop := errors.New("stop")
+
+	ctx := ContextWithRequestHook(context.Background(), func(req *http.Request) error {
+		return errStop
+	})
+
+	if _, err := c.UserGet(ctx, &UserGetInput{UserID: testID(t, testUserID)}); !errors.Is(err, errStop) {
+		t.Fatalf("err = %v", err)
+	}
+
+	if calls != 0 {
+		t.Fatal("the request was sent")
+	}
+}
+
+func TestResponseHookSeesEveryAttempt(t *testing.T) {
+	var statuses []int
+
+	n := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if n++; n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeTestJSON(w, http.StatusOK, map[string]any{"id": testUserID})
+	})
+
+	ctx := ContextWithResponseHook(context.Background(), func(req *http.Request, resp *http.Response) {
+		statuses = append(statuses, resp.StatusCode)
+	})
+
+	if _, err := c.UserGet(ctx, &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(statuses) != 2 || statuses[0] != http.StatusServiceUnavailable || statuses[1] != http.StatusOK {
+		t.Fatalf("statuses = %v", statuses)
+	}
+}
@@ -0,0 +1,240 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"net/http"
+	"strings"
+)
+
+type (
+	// Operation describes a Client method: its name, HTTP method and path
+	// template (e.g. "/api/1.0.0/users/%s").
+	Operation struct {
+		Name   string
+		Method string
+		Path   string
+		// Unsafe marks calls with side effects despite an idempotent method;
+		// they are only retried when they carry an Idempotency-Key
+		Unsafe bool
+	}
+)
+
+var (
+	// operations lists every call made by the Client methods.
+	operations = []Operation{
+		{Name: "AccessTokenCreate", Method: http.MethodGet, Path: AppTokenCreatePath, Unsafe: true},
+		{Name: "AccessTokenCreate", Method: http.MethodGet, Path: UserTokenCreatePath, Unsafe: true},
+		{Name: "AccessTokenGet", Method: http.MethodGet, Path: AccessTokenGetPath},
+		{Name: "AccessTokenUpdate", Method: http.MethodPut, Path: AccessTokenUpdatePath},
+		{Name: "AccessTokenRevoke", Method: http.MethodDelete, Path: AccessTokenRevokePath},
+		{Name: "ApplicationCreate", Method: http.MethodPost, Path: ApplicationCreatePath},
+		{Name: "ApplicationGet", Method: http.MethodGet, Path: ApplicationGetPath},
+		{Name: "ApplicationUpdate", Method: http.MethodPut, Path: ApplicationUpdatePath},
+		{Name: "ApplicationDelete", Method: http.MethodDelete, Path: ApplicationDeletePath},
+		{Name: "ApplicationList", Method: http.MethodGet, Path: ApplicationListPath},
+		{Name: "ArticleCreate", Method: http.MethodPost, Path: ArticleCreatePath},
+		{Name: "ArticleGet", Method: http.MethodGet, Path: ArticleGetPath},
+		{Name: "ArticleUpdate", Method: http.MethodPut, Path: ArticleUpdatePath},
+		{Name: "ArticleDelete", Method: http.MethodDelete, Path: ArticleDeletePath},
+		{Name: "ArticleList", Method: http.MethodGet, Path: ArticleListPath},
+		{Name: "AssetCreate", Method: http.MethodPost, Path: AssetCreatePath},
+		{Name: "AssetGet", Method: http.MethodGet, Path: AssetGetPath},
+		{Name: "AssetUpdate", Method: http.MethodPut, Path: AssetUpdatePath},
+		{Name: "AssetDelete", Method: http.MethodDelete, Path: AssetDeletePath},
+		{Name: "AssetList", Method: http.MethodGet, Path: AssetListPath},
+		{Name: "AssetUploadCreate", Method: http.MethodPost, Path: AssetUploadCreatePath},
+		{Name: "AssetUploadGet", Method: http.MethodGet, Path: AssetUploadGetPath},
+		{Name: "AssetUploadPart", Method: http.MethodPut, Path: AssetUploadPartPath},
+		{Name: "AssetUploadComplete", Method: http.MethodPost, Path: AssetUploadCompletePath},
+		{Name: "AudienceGet", Method: http.MethodGet, Path: AudienceGetPath},
+		{Name: "AudienceCreate", Method: http.MethodPost, Path: AudienceCreatePath},
+		{Name: "AudienceUpdate", Method: http.MethodPut, Path: AudienceUpdatePath},
+		{Name: "AudienceDelete", Method: http.MethodDelete, Path: AudienceDeletePath},
+		{Name: "AudienceList", Method: http.MethodGet, Path: AudienceListPath},
+		{Name: "CategoryGet", Method: http.MethodGet, Path: CategoryGetPath},
+		{Name: "CategoryCreate", Method: http.MethodPost, Path: CategoryCreatePath},
+		{Name: "CategoryUpdate", Method: http.MethodPut, Path: CategoryUpdatePath},
+		{Name: "CategoryDelete", Method: http.MethodDelete, Path: CategoryDeletePath},
+		{Name: "CategoryList", Method: http.MethodGet, Path: CategoryListPath},
+		{Name: "CreditGet", Method: http.MethodGet, Path: CreditGetPath},
+		{Name: "CreditUpdate", Method: http.MethodPut, Path: CreditUpdatePath},
+		{Name: "CreditCreate", Method: http.MethodPost, Path: CreditCreatePath},
+		{Name: "CreditList", Method: http.MethodGet, Path: CreditListPath},
+		{Name: "CreditInviteCreate", Method: http.MethodPost, Path: CreditInviteCreatePath},
+		{Name: "CreditInviteAccept", Method: http.MethodGet, Path: CreditInviteAcceptPath, Unsafe: true},
+		{Name: "DistributionGet", Method: http.MethodGet, Path: DistributionGetPath},
+		{Name: "DistributionCreate", Method: http.MethodPost, Path: DistributionCreatePath},
+		{Name: "DistributionUpdate", Method: http.MethodPut, Path: DistributionUpdatePath},
+		{Name: "DistributionDelete", Method: http.MethodDelete, Path: DistributionDeletePath},
+		{Name: "DistributionList", Method: http.MethodGet, Path: DistributionListPath},
+		{Name: "SendMail", Method: http.MethodPost, Path: SendMailPath},
+		{Name: "InstanceCreate", Method: http.MethodPost, Path: InstanceCreatePath},
+		{Name: "InstanceGet", Method: http.MethodGet, Path: InstanceGetPath},
+		{Name: "InstanceList", Method: http.MethodGet, Path: InstanceListPath},
+		{Name: "InstanceUpdate", Method: http.MethodPut, Path: InstanceUpdatePath},
+		{Name: "InstanceDelete", Method: http.MethodDelete, Path: InstanceDeletePath},
+		{Name: "JobCreate", Method: http.MethodPost, Path: JobCreatePath},
+		{Name: "JobGet", Method: http.MethodGet, Path: JobGetPath},
+		{Name: "JobUpdate", Method: http.MethodPut, Path: JobUpdatePath},
+		{Name: "JobList", Method: http.MethodGet, Path: JobListPath},
+		{Name: "JobRestart", Method: http.MethodPost, Path: JobRestartPath},
+		{Name: "JobCancel", Method: http.MethodDelete, Path: JobCancelPath},
+		{Name: "OptionGet", Method: http.MethodGet, Path: OptionGetPath},
+		{Name: "OptionList", Method: http.MethodGet, Path: OptionListPath},
+		{Name: "OptionUpdate", Method: http.MethodPut, Path: OptionUpdatePath},
+		{Name: "OptionRemove", Method: http.MethodDelete, Path: OptionRemovePath},
+		{Name: "PlanGet", Method: http.MethodGet, Path: PlanGetPath},
+		{Name: "PlanCreate", Method: http.MethodPost, Path: PlanCreatePath},
+		{Name: "PlanUpdate", Method: http.MethodPut, Path: PlanUpdatePath},
+		{Name: "PlanDelete", Method: http.MethodDelete, Path: PlanDeletePath},
+		{Name: "PlanList", Method: http.MethodGet, Path: PlanListPath},
+		{Name: "PlanSubscribe", Method: http.MethodPost, Path: PlanSubscribePath},
+		{Name: "PriceGet", Method: http.MethodGet, Path: PriceGetPath},
+		{Name: "PriceCreate", Method: http.MethodPost, Path: PriceCreatePath},
+		{Name: "PriceUpdate", Method: http.MethodPut, Path: PriceUpdatePath},
+		{Name: "PriceDelete", Method: http.MethodDelete, Path: PriceDeletePath},
+		{Name: "PriceList", Method: http.MethodGet, Path: PriceListPath},
+		{Name: "SendSMS", Method: http.MethodPost, Path: SMSSendPath},
+		{Name: "SubscriptionGet", Method: http.MethodGet, Path: SubscriptionGetPath},
+		{Name: "SubscriptionList", Method: http.MethodGet, Path: SubscriptionListPath},
+		{Name: "SubscriptionCreate", Method: http.MethodPost, Path: SubscriptionCreatePath},
+		{Name: "SubscriptionUpdate", Method: http.MethodPut, Path: SubscriptionUpdatePath},
+		{Name: "SubscriptionDelete", Method: http.MethodDelete, Path: SubscriptionDeletePath},
+		{Name: "TemplateGet", Method: http.MethodGet, Path: TemplateGetPath},
+		{Name: "TemplateList", Method: http.MethodGet, Path: TemplateListPath},
+		{Name: "TemplateCreate", Method: http.MethodPost, Path: TemplateCreatePath},
+		{Name: "TemplateUpdate", Method: http.MethodPut, Path: TemplateUpdatePath},
+		{Name: "TemplateDelete", Method: http.MethodDelete, Path: TemplateDeletePath},
+		{Name: "UserGet", Method: http.MethodGet, Path: UserGetPath},
+		{Name: "UserCreate", Method: http.MethodPost, Path: UserCreatePath},
+		{Name: "UserUpdate", Method: http.MethodPut, Path: UserUpdatePath},
+		{Name: "UserDelete", Method: http.MethodDelete, Path: UserDeletePath},
+		{Name: "UserList", Method: http.MethodGet, Path: UserListPath},
+		{Name: "UserImport", Method: http.MethodPost, Path: UserImportPath},
+		{Name: "UserExport", Method: http.MethodPost, Path: UserExportPath},
+	}
+)
+
+// OperationFor resolves the operation behind a request, so instrumentation can
+// report "UserGet" and its path template rather than the interpolated path.
+func OperationFor(params RequestContainer) (Operation, bool) {
+	return LookupOperation(params.Method(), params.Path())
+}
+
+// LookupOperation resolves the operation for an HTTP method and concrete path;
+// any query string is ignored. When several templates match, the one with
+// the most literal segments wins.
+func LookupOperation(method, path string) (Operation, bool) {
+	path, _, _ = strings.Cut(path, "?")
+
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+
+	var (
+		best     Operation
+		bestLits = -1
+	)
+
+	for _, op := range operations {
+		if op.Method != method {
+			continue
+		}
+
+		if lits, ok := matchPath(op.Path, segs); ok && lits > bestLits {
+			best, bestLits = op, lits
+		}
+	}
+
+	return best, bestLits >= 0
+}
+
+// Template renders the path with named placeholders, e.g.
+// "/api/1.0.0/users/{user_id}", as expected by url.template attributes.
+func (o Operation) Template() string {
+	segs := strings.Split(o.Path, "/")
+
+	for i, s := range segs {
+		if s != "%s" {
+			continue
+		}
+
+		name := "id"
+		if i > 0 {
+			name = singular(segs[i-1]) + "_id"
+		}
+
+		segs[i] = "{" + name + "}"
+	}
+
+	return strings.Join(segs, "/")
+}
+
+// PathParams returns the values of the %s placeholders of template in path.
+func PathParams(template, path string) []string {
+	path, _, _ = strings.Cut(path, "?")
+
+	tsegs := strings.Split(strings.Trim(template, "/"), "/")
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+
+	if len(tsegs) != len(segs) {
+		return nil
+	}
+
+	var out []string
+	for i, t := range tsegs {
+		if t == "%s" {
+			out = append(out, segs[i])
+		}
+	}
+
+	return out
+}
+
+func matchPath(template string, segs []string) (int, bool) {
+	tsegs := strings.Split(strings.Trim(template, "/"), "/")
+	if len(tsegs) != len(segs) {
+		return 0, false
+	}
+
+	lits := 0
+	for i, t := range tsegs {
+		switch {
+		case t == "%s":
+			if segs[i] == "" {
+				return 0, false
+			}
+		case t == segs[i]:
+			lits++
+		default:
+			return 0, false
+		}
+	}
+
+	return lits, true
+}
+
+func singular(s string) string {
+	switch {
+	case strings.HasSuffix(s, "ies"):
+		return strings.TrimSuffix(s, "ies") + "y"
+	case strings.HasSuffix(s, "s"):
+		return strings.TrimSuffix(s, "s")
+	}
+
+	return s
+}
@@ -20,6 +20,7 @@ package atomic
 import (
 	"context"
 	"fmt"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -67,6 +68,12 @@ func (c *Client) OptionList(ctx context.Context, params *OptionListInput) ([]*Op
 	return resp.Value(), nil
 }
 
+func (c *Client) OptionListAll(ctx context.Context, params *OptionListInput, opts ...PageOption) iter.Seq2[*Option, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*Option, error) {
+		return c.OptionList(ctx, params)
+	})
+}
+
 func (c *Client) OptionUpdate(ctx context.Context, params *OptionUpdateInput) (*Option, error) {
 	var resp ResponseProxy[Option]
 
@@ -20,6 +20,7 @@ package atomic
 import (
 	"context"
 	"fmt"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -113,6 +114,12 @@ func (c *Client) PlanList(ctx context.Context, params *PlanListInput) ([]*Plan,
 	return resp.Value(), nil
 }
 
+func (c *Client) PlanListAll(ctx context.Context, params *PlanListInput, opts ...PageOption) iter.Seq2[*Plan, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*Plan, error) {
+		return c.PlanList(ctx, params)
+	})
+}
+
 func (c *Client) PlanSubscribe(ctx context.Context, params *PlanSubscribeInput) (*Subscription, error) {
 	var resp ResponseProxy[Subscription]
 
@@ -20,6 +20,7 @@ package atomic
 import (
 	"context"
 	"fmt"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -101,16 +102,25 @@ func (c *Client) PriceDelete(ctx context.Context, params *PriceDeleteInput) erro
 func (c *Client) PriceList(ctx context.Context, params *PriceListInput) ([]*Price, error) {
 	var resp ResponseProxy[[]*Price]
 
-	if err := params.Validate(); err != nil {
+	req := NewRequest(ctx, PriceListPath, params).Get()
+
+	// validated here as well so backends other than ApiBackend keep it
+	if err := ValidateParams(req); err != nil {
 		return nil, err
 	}
 
 	if err := c.Backend.ExecContext(
 		ctx,
-		NewRequest(ctx, PriceListPath, params).Get(),
+		req,
 		&resp); err != nil {
 		return nil, err
 	}
 
 	return resp.Value(), nil
 }
+
+func (c *Client) PriceListAll(ctx context.Context, params *PriceListInput, opts ...PageOption) iter.Seq2[*Price, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*Price, error) {
+		return c.PriceList(ctx, params)
+	})
+}
@@ -0,0 +1,263 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// RateLimit is a token bucket refilled at Rate tokens per second up to
+	// Burst tokens; a zero Rate leaves only server driven throttling.
+	RateLimit struct {
+		Rate  float64
+		Burst int
+	}
+
+	// RateLimiter throttles calls per host and per Atomic-Instance. It is safe
+	// for concurrent use and can be shared by several clients created with New
+	// so they draw from the same buckets.
+	RateLimiter struct {
+		limit     RateLimit
+		hosts     map[string]RateLimit
+		instances map[string]RateLimit
+		buckets   map[string]*rateBucket
+		mu        sync.Mutex
+	}
+
+	rateBucket struct {
+		limit RateLimit
+		// burst is the configured burst, which advertised limits never
+		// raise
+		burst        int
+		tokens       float64
+		last         time.Time
+		blockedUntil time.Time
+	}
+)
+
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limit:     limit,
+		hosts:     make(map[string]RateLimit),
+		instances: make(map[string]RateLimit),
+		buckets:   make(map[string]*rateBucket),
+	}
+}
+
+// WithRateLimiter makes every call wait for a token before it is sent; pass
+// the same limiter to several clients to share a budget between them.
+func WithRateLimiter(l *RateLimiter) ApiOption {
+	return func(c *ApiConfig) {
+		c.RateLimiter = l
+	}
+}
+
+// SetHostLimit overrides the default limit for every instance on host.
+func (l *RateLimiter) SetHostLimit(host string, limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.hosts[host] = limit
+	l.reset(host)
+}
+
+// SetInstanceLimit overrides the limit for a single instance on host.
+func (l *RateLimiter) SetInstanceLimit(host, instance string, limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.instances[rateKey(host, instance)] = limit
+	l.reset(host)
+}
+
+// Wait blocks until a token is available for the host/instance pair or ctx
+// is done.
+func (l *RateLimiter) Wait(ctx context.Context, host, instance string) error {
+	l.mu.Lock()
+	b := l.bucket(host, instance)
+	delay, taken := b.reserve(time.Now())
+	l.mu.Unlock()
+
+	if err := sleepContext(ctx, delay); err != nil {
+		// hand the token back, the call never went out
+		if taken {
+			l.mu.Lock()
+			b.tokens = min(b.tokens+1, b.capacity())
+			l.mu.Unlock()
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Observe adapts the bucket to the X-RateLimit-* and Retry-After headers the
+// server returned, so the next callers block instead of collecting 429s. The
+// bucket holds the lower of the configured burst and the limit last
+// advertised, so it grows back when the server raises the limit.
+func (l *RateLimiter) Observe(host, instance string, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(host, instance)
+	b.refill(now)
+
+	if limit, err := strconv.Atoi(resp.Header.Get(RateLimitLimitHeader)); err == nil && limit > 0 {
+		b.limit.Burst = limit
+		if b.burst > 0 {
+			b.limit.Burst = min(b.burst, limit)
+		}
+		b.tokens = min(b.tokens, b.capacity())
+	}
+
+	if remaining, err := strconv.ParseFloat(resp.Header.Get(RateLimitRemainingHeader), 64); err == nil {
+		b.tokens = min(b.tokens, remaining)
+
+		if remaining <= 0 {
+			if reset, ok := parseRateLimitReset(resp.Header.Get(RateLimitResetHeader), now); ok {
+				b.blockedUntil = maxTime(b.blockedUntil, reset)
+			}
+		}
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if after, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			b.blockedUntil = maxTime(b.blockedUntil, now.Add(after))
+		}
+	}
+}
+
+func (l *RateLimiter) bucket(host, instance string) *rateBucket {
+	key := rateKey(host, instance)
+
+	if b, ok := l.buckets[key]; ok {
+		return b
+	}
+
+	limit := l.limit
+	if hl, ok := l.hosts[host]; ok {
+		limit = hl
+	}
+	if il, ok := l.instances[key]; ok {
+		limit = il
+	}
+
+	b := &rateBucket{
+		limit:  limit,
+		burst:  limit.Burst,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+	l.buckets[key] = b
+
+	return b
+}
+
+// reset drops the buckets for host so they are rebuilt with the new limits.
+func (l *RateLimiter) reset(host string) {
+	for key := range l.buckets {
+		if key == host || strings.HasPrefix(key, host+"/") {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (b *rateBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 && b.limit.Rate > 0 {
+		b.tokens = min(b.tokens+elapsed.Seconds()*b.limit.Rate, b.capacity())
+	}
+	b.last = now
+}
+
+func (b *rateBucket) capacity() float64 {
+	return float64(max(b.limit.Burst, 1))
+}
+
+// reserve takes a token, if the bucket has a rate, and returns how long the
+// caller must wait for it; the balance may go negative, which queues later
+// callers behind this one.
+func (b *rateBucket) reserve(now time.Time) (time.Duration, bool) {
+	var delay time.Duration
+
+	if now.Before(b.blockedUntil) {
+		delay = b.blockedUntil.Sub(now)
+	}
+
+	if b.limit.Rate <= 0 {
+		return delay, false
+	}
+
+	b.refill(now)
+	b.tokens--
+
+	if b.tokens < 0 {
+		delay = max(delay, time.Duration(-b.tokens/b.limit.Rate*float64(time.Second)))
+	}
+
+	return delay, true
+}
+
+func rateKey(host, instance string) string {
+	if instance == "" {
+		return host
+	}
+
+	return host + "/" + instance
+}
+
+// parseRateLimitReset accepts both a delta in seconds and a unix timestamp,
+// the two conventions used for X-RateLimit-Reset.
+func parseRateLimitReset(v string, now time.Time) (time.Time, bool) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return time.Time{}, false
+	}
+
+	if n > 1_000_000_000 {
+		return time.Unix(n, 0), true
+	}
+
+	return now.Add(time.Duration(n) * time.Second), true
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
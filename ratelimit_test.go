@@ -0,0 +1,192 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterPacesCalls(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Rate: 20, Burst: 1})
+
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		if err := l.Wait(context.Background(), "api", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first token is free, the other four arrive every 50ms
+	if d := time.Since(start); d < 190*time.Millisecond || d > time.Second {
+		t.Fatalf("5 calls took %s, want ~200ms", d)
+	}
+}
+
+func TestRateLimiterInstances(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Rate: 1, Burst: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// each instance has its own bucket
+	for _, instance := range []string{"a", "b", ""} {
+		if err := l.Wait(ctx, "api", instance); err != nil {
+			t.Fatal(instance, err)
+		}
+	}
+
+	if err := l.Wait(ctx, "api", "a"); err == nil {
+		t.Fatal("second call on instance a was not throttled")
+	}
+}
+
+func TestRateLimiterObserveReset(t *testing.T) {
+	l := NewRateLimiter(RateLimit{})
+
+	l.Observe("api", "", &http.Response{
+		StatusCode: http.StatusOK,
+		Header: header(
+			RateLimitRemainingHeader, "0",
+			RateLimitResetHeader, "1",
+		),
+	})
+
+	start := time.Now()
+
+	if err := l.Wait(context.Background(), "api", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Fatalf("waited %s, want the 1s reset", d)
+	}
+}
+
+func TestRateLimiterObserveRetryAfter(t *testing.T) {
+	l := NewRateLimiter(RateLimit{})
+
+	l.Observe("api", "x", &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": {"30"}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := l.Wait(ctx, "api", "x"); err == nil {
+		t.Fatal("expected the Retry-After block")
+	}
+
+	if err := l.Wait(context.Background(), "api", "y"); err != nil {
+		t.Fatal("other instances must not be blocked:", err)
+	}
+}
+
+func TestRateLimiterObserveLimit(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Rate: 100, Burst: 50})
+
+	l.Observe("api", "", &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     header(RateLimitLimitHeader, strconv.Itoa(5)),
+	})
+
+	b := l.bucket("api", "")
+	if b.limit.Burst != 5 || b.tokens > 5 {
+		t.Fatalf("burst = %d, tokens = %v, want 5", b.limit.Burst, b.tokens)
+	}
+
+	// a larger advertised limit restores the configured burst, no more
+	for _, tt := range []struct {
+		advertised string
+		want       int
+	}{
+		{"500", 50},
+		{"20", 20},
+		{"60", 50},
+	} {
+		l.Observe("api", "", &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     header(RateLimitLimitHeader, tt.advertised),
+		})
+
+		if b.limit.Burst != tt.want {
+			t.Fatalf("advertised %s: burst = %d, want %d", tt.advertised, b.limit.Burst, tt.want)
+		}
+	}
+
+	// without a configured burst the advertised limit is used as is
+	l = NewRateLimiter(RateLimit{Rate: 100})
+	for _, limit := range []string{"5", "500"} {
+		l.Observe("api", "", &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     header(RateLimitLimitHeader, limit),
+		})
+
+		if got := strconv.Itoa(l.bucket("api", "").limit.Burst); got != limit {
+			t.Fatalf("burst = %s, want %s", got, limit)
+		}
+	}
+}
+
+func TestRateLimiterCancelRefund(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Rate: 0.1, Burst: 1})
+
+	if err := l.Wait(context.Background(), "api", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := l.Wait(ctx, "api", ""); err == nil {
+		t.Fatal("expected the context error")
+	}
+
+	if b := l.bucket("api", ""); b.tokens > 0.5 {
+		t.Fatalf("tokens = %v after refund, want <= 0", b.tokens)
+	}
+
+	// without a rate nothing is taken, so nothing is handed back
+	l = NewRateLimiter(RateLimit{Burst: 3})
+	l.Observe("api", "", &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     header("Retry-After", "30", RateLimitRemainingHeader, "1"),
+	})
+
+	if err := l.Wait(ctx, "api", ""); err == nil {
+		t.Fatal("expected the context error")
+	}
+
+	if b := l.bucket("api", ""); b.tokens != 1 {
+		t.Fatalf("tokens = %v, want 1", b.tokens)
+	}
+}
+
+func header(kv ...string) http.Header {
+	h := make(http.Header)
+	for i := 0; i < len(kv); i += 2 {
+		h.Set(kv[i], kv[i+1])
+	}
+
+	return h
+}
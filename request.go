@@ -29,16 +29,22 @@ import (
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/google/go-querystring/query"
+	"golang.org/x/oauth2"
 )
 
 type (
 	Params struct {
-		Context  context.Context `schema:"-" json:"-"`
-		Headers  http.Header     `schema:"-" json:"-"`
-		NoAuth   bool            `schema:"-" json:"-"`
-		Expand   []string        `schema:"expand,omitempty" json:"expand,omitempty"`
-		Fields   []string        `schema:"fields,omitempty" json:"fields,omitempty"`
-		Instance *string         `schema:"instance,omitempty" json:"-"`
+		Context        context.Context    `schema:"-" json:"-"`
+		Headers        http.Header        `schema:"-" json:"-"`
+		NoAuth         bool               `schema:"-" json:"-"`
+		IdempotencyKey string             `schema:"-" json:"-"`
+		SkipValidation bool               `schema:"-" json:"-"`
+		Query          url.Values         `schema:"-" json:"-"`
+		AccessToken    string             `schema:"-" json:"-"`
+		TokenSource    oauth2.TokenSource `schema:"-" json:"-"`
+		Expand         []string           `schema:"expand,omitempty" json:"expand,omitempty"`
+		Fields         []string           `schema:"fields,omitempty" json:"fields,omitempty"`
+		Instance       *string            `schema:"instance,omitempty" json:"-"`
 	}
 
 	ListParams struct {
@@ -63,6 +69,7 @@ type (
 		method        string
 		encoding      ParamsEncoding
 		body          io.Reader
+		bodyFunc      func() io.Reader
 		path          string
 		contentType   string
 	}
@@ -176,6 +183,13 @@ func (p *RequestProxy[T]) WithBody(body io.Reader) *RequestProxy[T] {
 	return p
 }
 
+// WithBodyFunc sets a body that is produced anew for every attempt, e.g. a
+// streamed upload; it takes precedence over WithBody.
+func (p *RequestProxy[T]) WithBodyFunc(fn func() io.Reader) *RequestProxy[T] {
+	p.bodyFunc = fn
+	return p
+}
+
 func (p *RequestProxy[T]) RequestParams() Params {
 	return p.requestParams
 }
@@ -189,22 +203,46 @@ func (p *RequestProxy[T]) Method() string {
 }
 
 func (p *RequestProxy[T]) Path() string {
+	values := url.Values{}
+
 	if p.encoding == ParamsEncodingQuery {
-		values, err := query.Values(p.methodParams)
-		if err != nil {
-			return p.path
-		}
+		values = queryValues(p.methodParams)
+	}
 
-		if len(values) > 0 {
-			return p.path + "?" + values.Encode()
-		}
+	// request level query params override the ones from the method params
+	for k, v := range p.requestParams.Query {
+		values[k] = v
 	}
 
-	return p.path
+	if len(values) == 0 {
+		return p.path
+	}
+
+	if strings.Contains(p.path, "?") {
+		return p.path + "&" + values.Encode()
+	}
+
+	return p.path + "?" + values.Encode()
 }
 
 func (p *RequestProxy[T]) Body() io.Reader {
+	if p.bodyFunc != nil {
+		return p.bodyFunc()
+	}
+
 	if p.body != nil {
+		// hand out a fresh reader over in-memory and random access bodies so
+		// the request can be rebuilt for every retry attempt
+		switch b := p.body.(type) {
+		case *bytes.Buffer:
+			return bytes.NewReader(b.Bytes())
+		case interface {
+			io.ReaderAt
+			Size() int64
+		}:
+			return io.NewSectionReader(b, 0, b.Size())
+		}
+
 		return p.body
 	}
 
@@ -264,6 +302,16 @@ func (p *RequestProxy[T]) MarshalJSON() ([]byte, error) {
 	return json.Marshal(methodMap)
 }
 
+// queryValues encodes query method params the way Path sends them.
+func queryValues(v any) url.Values {
+	values, err := query.Values(v)
+	if err != nil {
+		return url.Values{}
+	}
+
+	return values
+}
+
 // jsonToQuery marshals a struct to JSON, then builds a query string from the
 // resulting key/value pairs. Fields with json:"-" or omitempty zero values are
 // automatically excluded by json.Marshal. Zero values that survive marshaling
@@ -18,6 +18,7 @@
 package atomic
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 )
@@ -28,6 +29,11 @@ type (
 		Body       json.RawMessage `json:"-"`
 		Status     string          `json:"-"`
 		StatusCode int             `json:"-"`
+		// RequestID is the server assigned id for the call, quote it when
+		// reporting issues
+		RequestID string `json:"-"`
+		// Attempts is the number of attempts made, including retries
+		Attempts int `json:"-"`
 	}
 
 	Resource[T any] struct {
@@ -39,8 +45,31 @@ type (
 		SetLastResponse(resp *Response)
 		Response() any
 	}
+
+	responseCaptureKey struct{}
+)
+
+const (
+	RequestIDHeader = "X-Request-Id"
 )
 
+// ContextWithResponse returns a context that makes the backend copy the HTTP
+// response metadata of the call into resp, for both successful and failed
+// calls; when no response arrived at all only Attempts is set. This exposes the metadata of high level methods like UserGet:
+//
+//	var resp atomic.Response
+//	user, err := client.UserGet(atomic.ContextWithResponse(ctx, &resp), params)
+//	log.Println(resp.RequestID)
+func ContextWithResponse(ctx context.Context, resp *Response) context.Context {
+	return context.WithValue(ctx, responseCaptureKey{}, resp)
+}
+
+func captureResponse(ctx context.Context, resp *Response) {
+	if capture, ok := ctx.Value(responseCaptureKey{}).(*Response); ok && capture != nil {
+		*capture = *resp
+	}
+}
+
 func (r *Resource[T]) SetLastResponse(resp *Response) {
 	r.LastResponse = resp
 }
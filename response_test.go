@@ -0,0 +1,99 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestResponseMetadata(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(RequestIDHeader, "req-1")
+		w.Header().Set("X-Custom", "yes")
+		writeTestJSON(w, http.StatusOK, map[string]any{"id": testUserID})
+	})
+
+	var resp Response
+	ctx := ContextWithResponse(context.Background(), &resp)
+
+	if _, err := c.UserGet(ctx, &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK || resp.Status != "200 OK" || resp.RequestID != "req-1" || resp.Attempts != 1 {
+		t.Fatalf("resp = %+v", resp)
+	}
+
+	if resp.Headers.Get("X-Custom") != "yes" || len(resp.Body) == 0 {
+		t.Fatalf("headers = %v, body = %q", resp.Headers, resp.Body)
+	}
+}
+
+func TestResponseMetadataOnError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(RequestIDHeader, "req-2")
+		writeTestJSON(w, http.StatusNotFound, map[string]any{"code": "not_found", "message": "no such user"})
+	})
+
+	var resp Response
+	ctx := ContextWithResponse(context.Background(), &resp)
+
+	_, err := c.UserGet(ctx, &UserGetInput{UserID: testID(t, testUserID)})
+
+	var e Error
+	if !errors.As(err, &e) || e.RequestID != "req-2" {
+		t.Fatalf("err = %#v", err)
+	}
+
+	if resp.StatusCode != http.StatusNotFound || resp.RequestID != "req-2" {
+		t.Fatalf("resp = %+v", resp)
+	}
+}
+
+func TestResponseMetadataOnTransportError(t *testing.T) {
+	c := New(WithBaseURL(deadEndpoint(t)), WithToken("test-token"), WithRetryPolicy(testRetryPolicy(3)))
+
+	// left over from an earlier call
+	resp := Response{StatusCode: http.StatusOK, RequestID: "req-1"}
+	ctx := ContextWithResponse(context.Background(), &resp)
+
+	if _, err := c.UserGet(ctx, &UserGetInput{UserID: testID(t, testUserID)}); err == nil {
+		t.Fatal("expected a connection error")
+	}
+
+	if resp.StatusCode != 0 || resp.RequestID != "" || resp.Attempts != 3 {
+		t.Fatalf("resp = %+v", resp)
+	}
+}
+
+func TestResourceLastResponse(t *testing.T) {
+	var r Resource[User]
+
+	r.SetLastResponse(&Response{StatusCode: http.StatusCreated})
+
+	if r.LastResponse == nil || r.LastResponse.StatusCode != http.StatusCreated {
+		t.Fatalf("LastResponse = %+v", r.LastResponse)
+	}
+
+	if r.Response() != any(r.Pointer()) {
+		t.Fatal("Response must decode into the resource value")
+	}
+}
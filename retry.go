@@ -0,0 +1,250 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+type (
+	// RetryPolicy controls how ApiBackend retries failed calls. A call is only
+	// retried when it is safe to repeat: the method is idempotent or the request
+	// carries an Idempotency-Key header, and the body can be re-created.
+	RetryPolicy struct {
+		// MaxAttempts is the total number of attempts, including the first;
+		// values below 2 disable retries
+		MaxAttempts int
+
+		// MinBackoff and MaxBackoff bound the jittered exponential delay
+		// between attempts
+		MinBackoff time.Duration
+		MaxBackoff time.Duration
+
+		// MaxRetryAfter is the longest server requested Retry-After delay that
+		// will be honored; longer delays fail the call instead of waiting
+		MaxRetryAfter time.Duration
+
+		// RetryableStatus overrides the set of HTTP status codes that are
+		// considered transient
+		RetryableStatus []int
+
+		// ShouldRetry, when set, replaces the default classification of
+		// responses and transport errors; the idempotency and body checks
+		// still apply
+		ShouldRetry func(resp *http.Response, err error) bool
+	}
+)
+
+var (
+	defaultRetryableStatus = []int{
+		http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+)
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:   3,
+		MinBackoff:    250 * time.Millisecond,
+		MaxBackoff:    5 * time.Second,
+		MaxRetryAfter: 30 * time.Second,
+	}
+}
+
+// WithRetryPolicy replaces the default retry policy; pass RetryPolicy{} to
+// disable retries entirely.
+func WithRetryPolicy(policy RetryPolicy) ApiOption {
+	return func(c *ApiConfig) {
+		c.Retry = policy
+	}
+}
+
+func WithMaxRetries(retries int) ApiOption {
+	return func(c *ApiConfig) {
+		c.Retry.MaxAttempts = retries + 1
+	}
+}
+
+// retryDelay returns how long to wait before the next attempt and whether the
+// request should be retried at all.
+func (p RetryPolicy) retryDelay(attempt int, req *http.Request, op Operation, resp *http.Response, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	if err != nil && req.Context().Err() != nil {
+		return 0, false
+	}
+
+	if !isIdempotent(req, op) || !isRewindable(req) {
+		return 0, false
+	}
+
+	if p.ShouldRetry != nil {
+		if !p.ShouldRetry(resp, err) {
+			return 0, false
+		}
+	} else if !p.isRetryable(resp, err) {
+		return 0, false
+	}
+
+	delay := p.backoff(attempt)
+
+	if resp != nil {
+		if after, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if p.MaxRetryAfter > 0 && after > p.MaxRetryAfter {
+				return 0, false
+			}
+			delay = max(delay, after)
+		}
+	}
+
+	return delay, true
+}
+
+func (p RetryPolicy) isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return isTransientError(err)
+	}
+
+	statuses := p.RetryableStatus
+	if statuses == nil {
+		statuses = defaultRetryableStatus
+	}
+
+	for _, s := range statuses {
+		if resp.StatusCode == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff is a capped exponential delay with equal jitter, so consecutive
+// attempts from many goroutines don't stampede the server in lockstep.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if p.MinBackoff <= 0 {
+		return 0
+	}
+
+	d := p.MinBackoff << (attempt - 1)
+	if d <= 0 || (p.MaxBackoff > 0 && d > p.MaxBackoff) {
+		d = p.MaxBackoff
+	}
+
+	half := d / 2
+
+	return half + rand.N(half+1)
+}
+
+// isIdempotent reports whether req can be repeated without repeating its
+// effects: its method is idempotent and op isn't Unsafe, or it carries an
+// Idempotency-Key.
+func isIdempotent(req *http.Request, op Operation) bool {
+	if req.Header.Get(IdempotencyKeyHeader) != "" {
+		return true
+	}
+
+	if op.Unsafe {
+		return false
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+// isRewindable reports whether the request body can be sent again; bodies
+// backed by one-shot readers have no GetBody and cannot be replayed.
+func isRewindable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func isTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+
+	var oerr *net.OpError
+	return errors.As(err, &oerr)
+}
+
+// parseRetryAfter accepts both the delay-seconds and HTTP-date forms.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,186 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransientStatus(t *testing.T) {
+	var calls atomic.Int32
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeTestJSON(w, http.StatusOK, map[string]any{"id": testUserID})
+	})
+
+	var resp Response
+	ctx := ContextWithResponse(context.Background(), &resp)
+
+	if _, err := c.UserGet(ctx, &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls.Load() != 3 || resp.Attempts != 3 {
+		t.Fatalf("calls = %d, attempts = %d, want 3", calls.Load(), resp.Attempts)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var calls atomic.Int32
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	_, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)})
+
+	var e Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusBadGateway {
+		t.Fatalf("err = %v, want 502", err)
+	}
+
+	if calls.Load() != 3 {
+		t.Fatalf("calls = %d, want 3", calls.Load())
+	}
+}
+
+func TestRetryNotIdempotent(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(t *testing.T, c *Client) error
+	}{
+		{"post", func(t *testing.T, c *Client) error {
+			_, err := c.UserCreate(context.Background(), &UserCreateInput{})
+			return err
+		}},
+		{"token create", func(t *testing.T, c *Client) error {
+			_, err := c.AccessTokenCreate(context.Background(), &AccessTokenCreateInput{UserID: testID(t, testUserID)})
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			})
+
+			if err := tt.call(t, c); err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if calls.Load() != 1 {
+				t.Fatalf("calls = %d, want 1", calls.Load())
+			}
+		})
+	}
+}
+
+func TestRetryUnsafeWithIdempotencyKey(t *testing.T) {
+	var keys []string
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader))
+		if len(keys) < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeTestJSON(w, http.StatusOK, map[string]any{"id": testAppID})
+	}, WithIdempotencyKeys())
+
+	if _, err := c.AccessTokenCreate(context.Background(), &AccessTokenCreateInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Fatalf("keys = %q, want the same key twice", keys)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		writeTestJSON(w, http.StatusOK, map[string]any{"id": testUserID})
+	})
+
+	start := time.Now()
+
+	if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := time.Since(start); d < time.Second {
+		t.Fatalf("retried after %s, want the 1s Retry-After", d)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	var calls atomic.Int32
+
+	policy := testRetryPolicy(3)
+	policy.MaxRetryAfter = time.Second
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}, WithRetryPolicy(policy))
+
+	if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if calls.Load() != 1 {
+		t.Fatalf("calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d, ok := parseRetryAfter("3"); !ok || d != 3*time.Second {
+		t.Fatalf("got %s %v", d, ok)
+	}
+
+	if d, ok := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); !ok || d < 59*time.Minute {
+		t.Fatalf("got %s %v", d, ok)
+	}
+
+	for _, v := range []string{"", "-1", "soon"} {
+		if _, ok := parseRetryAfter(v); ok {
+			t.Fatalf("%q parsed", v)
+		}
+	}
+}
@@ -0,0 +1,485 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// SecretSink receives the value of each new access token, e.g. to update
+	// the configuration of the services using it.
+	SecretSink interface {
+		StoreSecret(ctx context.Context, secret string) error
+	}
+
+	SecretSinkFunc func(ctx context.Context, secret string) error
+
+	// FileSecretSink writes the token to a file of its own.
+	FileSecretSink struct {
+		Path string
+	}
+
+	// EnvFileSecretSink sets Name in a dotenv style file, keeping its other
+	// lines.
+	EnvFileSecretSink struct {
+		Path string
+		Name string
+	}
+
+	RotationState string
+
+	// Rotation records one replacement of an access token.
+	Rotation struct {
+		Old         *AccessToken
+		New         *AccessToken
+		State       RotationState
+		StartedAt   time.Time
+		CompletedAt time.Time
+		Err         error
+
+		oldSecret string
+		newSecret string
+	}
+
+	// TokenRotator replaces an access token before it expires: it creates a
+	// new token, stores it in the sinks, waits for the overlap window so every
+	// consumer picks it up, then revokes the old token.
+	TokenRotator struct {
+		client   *Client
+		params   AccessTokenCreateInput
+		sinks    []SecretSink
+		overlap  time.Duration
+		before   time.Duration
+		interval time.Duration
+		retry    time.Duration
+
+		rotating sync.Mutex
+		mu       sync.Mutex
+		current  *AccessToken
+		secret   string
+		since    time.Time
+		history  []*Rotation
+		// pending rotations stored their new token but failed to revoke the
+		// old one
+		pending []*Rotation
+	}
+
+	RotatorOption func(*TokenRotator)
+)
+
+const (
+	// RotationStored means the new token is in the sinks and the old one is
+	// still valid; it can be rolled back.
+	RotationStored     RotationState = "stored"
+	RotationCompleted  RotationState = "completed"
+	RotationFailed     RotationState = "failed"
+	RotationRolledBack RotationState = "rolled_back"
+
+	DefaultRotationOverlap  = 5 * time.Minute
+	DefaultRotateBefore     = 24 * time.Hour
+	DefaultRotationInterval = 30 * 24 * time.Hour
+	DefaultRotationRetry    = time.Minute
+)
+
+var (
+	ErrRollbackUnavailable = errors.New("rotation can't be rolled back")
+)
+
+func (f SecretSinkFunc) StoreSecret(ctx context.Context, secret string) error {
+	return f(ctx, secret)
+}
+
+func (s FileSecretSink) StoreSecret(_ context.Context, secret string) error {
+	return writeFile(s.Path, []byte(secret), 0o600)
+}
+
+func (s EnvFileSecretSink) StoreSecret(_ context.Context, secret string) error {
+	data, err := os.ReadFile(s.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+
+	found := false
+
+	for i, line := range lines {
+		key, _, ok := strings.Cut(strings.TrimPrefix(strings.TrimSpace(line), "export "), "=")
+		if ok && strings.TrimSpace(key) == s.Name {
+			lines[i] = s.Name + "=" + secret
+			found = true
+		}
+	}
+
+	if !found {
+		lines = append(lines, s.Name+"="+secret)
+	}
+
+	return writeFile(s.Path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+}
+
+func WithSecretSinks(sinks ...SecretSink) RotatorOption {
+	return func(r *TokenRotator) {
+		r.sinks = append(r.sinks, sinks...)
+	}
+}
+
+// WithRotationOverlap sets how long the old token stays valid after the new
+// one is stored.
+func WithRotationOverlap(d time.Duration) RotatorOption {
+	return func(r *TokenRotator) {
+		r.overlap = d
+	}
+}
+
+// WithRotateBefore sets how long before expiry the replacement is created.
+func WithRotateBefore(d time.Duration) RotatorOption {
+	return func(r *TokenRotator) {
+		r.before = d
+	}
+}
+
+// WithRotationInterval sets how often tokens without an expiry are rotated.
+func WithRotationInterval(d time.Duration) RotatorOption {
+	return func(r *TokenRotator) {
+		r.interval = d
+	}
+}
+
+// WithRotationRetry sets the delay before Run retries a failed rotation or
+// revoke; it doubles with every further failure, up to an hour.
+func WithRotationRetry(d time.Duration) RotatorOption {
+	return func(r *TokenRotator) {
+		r.retry = d
+	}
+}
+
+// NewTokenRotator rotates current, which may be nil to start with a new
+// token, creating replacements with params.
+func NewTokenRotator(client *Client, params AccessTokenCreateInput, current *AccessToken, opts ...RotatorOption) *TokenRotator {
+	r := &TokenRotator{
+		client:   client,
+		params:   params,
+		overlap:  DefaultRotationOverlap,
+		before:   DefaultRotateBefore,
+		interval: DefaultRotationInterval,
+		retry:    DefaultRotationRetry,
+		current:  current,
+		since:    time.Now(),
+	}
+
+	if current != nil {
+		r.secret, _, _ = accessTokenSecret(current)
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Current returns the token in use.
+func (r *TokenRotator) Current() *AccessToken {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.current
+}
+
+// History returns all rotations, oldest first.
+func (r *TokenRotator) History() []*Rotation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return slices.Clone(r.history)
+}
+
+// Run rotates the token whenever it is due until ctx is done, retrying
+// failures with backoff. A rotation that stored its new token but could not
+// revoke the old one is not repeated; only the revoke is retried.
+func (r *TokenRotator) Run(ctx context.Context) error {
+	failures := 0
+
+	for {
+		wait := r.next()
+		if failures > 0 {
+			wait = min(r.retry<<min(failures-1, 6), time.Hour)
+		}
+
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+
+		var err error
+		if r.hasPending() {
+			err = r.revokePending(ctx)
+		} else {
+			_, err = r.Rotate(ctx)
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failures++
+			continue
+		}
+
+		failures = 0
+	}
+}
+
+// Rotate replaces the current token now. If storing the new token fails it
+// is revoked and the sinks are restored; if revoking the old one fails, or
+// ctx is done during the overlap, the rotation stays RotationStored with Err
+// set until Run revokes it. It can be rolled back until then, including
+// during the overlap.
+func (r *TokenRotator) Rotate(ctx context.Context) (*Rotation, error) {
+	rot, err := r.stage(ctx)
+	if err != nil {
+		return rot, err
+	}
+
+	if rot.Old == nil {
+		r.complete(rot)
+		return rot, nil
+	}
+
+	if err := sleepContext(ctx, r.overlap); err != nil {
+		r.postpone(rot)
+		return rot, r.fail(rot, err)
+	}
+
+	r.rotating.Lock()
+	defer r.rotating.Unlock()
+
+	r.mu.Lock()
+	state := rot.State
+	r.mu.Unlock()
+
+	// rolled back during the overlap
+	if state != RotationStored {
+		return rot, nil
+	}
+
+	if err := r.revoke(ctx, rot.Old); err != nil {
+		r.postpone(rot)
+		return rot, r.fail(rot, err)
+	}
+
+	r.complete(rot)
+
+	return rot, nil
+}
+
+// stage creates the new token and stores it in the sinks.
+func (r *TokenRotator) stage(ctx context.Context) (*Rotation, error) {
+	r.rotating.Lock()
+	defer r.rotating.Unlock()
+
+	r.mu.Lock()
+	rot := &Rotation{
+		Old:       r.current,
+		State:     RotationFailed,
+		StartedAt: time.Now(),
+		oldSecret: r.secret,
+	}
+	r.history = append(r.history, rot)
+	r.mu.Unlock()
+
+	params := r.params
+
+	tok, err := r.client.AccessTokenCreate(ctx, &params)
+	if err != nil {
+		return rot, r.fail(rot, err)
+	}
+
+	rot.New = tok
+
+	secret, _, err := accessTokenSecret(tok)
+	if err != nil {
+		return rot, r.fail(rot, errors.Join(err, r.revokeNew(ctx, tok)))
+	}
+
+	rot.newSecret = secret
+
+	if err := r.store(ctx, secret); err != nil {
+		if rot.oldSecret != "" {
+			if rerr := r.store(ctx, rot.oldSecret); rerr != nil {
+				err = errors.Join(err, fmt.Errorf("restore old token: %w", rerr))
+			}
+		}
+		return rot, r.fail(rot, errors.Join(err, r.revokeNew(ctx, tok)))
+	}
+
+	r.mu.Lock()
+	r.current, r.secret, r.since = tok, secret, time.Now()
+	rot.State = RotationStored
+	r.mu.Unlock()
+
+	return rot, nil
+}
+
+// Rollback restores the old token of a rotation that has not revoked it yet
+// and revokes the new one.
+func (r *TokenRotator) Rollback(ctx context.Context, rot *Rotation) error {
+	r.rotating.Lock()
+	defer r.rotating.Unlock()
+
+	r.mu.Lock()
+	state := rot.State
+	r.mu.Unlock()
+
+	if state != RotationStored || rot.Old == nil || rot.oldSecret == "" {
+		return ErrRollbackUnavailable
+	}
+
+	if err := r.store(ctx, rot.oldSecret); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.current, r.secret = rot.Old, rot.oldSecret
+	rot.State = RotationRolledBack
+	r.pending = slices.DeleteFunc(r.pending, func(p *Rotation) bool { return p == rot })
+	r.mu.Unlock()
+
+	return r.revoke(ctx, rot.New)
+}
+
+func (r *TokenRotator) hasPending() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.pending) > 0
+}
+
+// revokePending retries the revoke of old tokens left by earlier rotations.
+func (r *TokenRotator) revokePending(ctx context.Context) error {
+	r.rotating.Lock()
+	defer r.rotating.Unlock()
+
+	r.mu.Lock()
+	pending := slices.Clone(r.pending)
+	r.mu.Unlock()
+
+	for _, rot := range pending {
+		if err := r.revoke(ctx, rot.Old); err != nil {
+			return r.fail(rot, err)
+		}
+
+		r.complete(rot)
+	}
+
+	return nil
+}
+
+// postpone leaves the revoke of a stored rotation to Run.
+func (r *TokenRotator) postpone(rot *Rotation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if rot.State == RotationStored && !slices.Contains(r.pending, rot) {
+		r.pending = append(r.pending, rot)
+	}
+}
+
+func (r *TokenRotator) complete(rot *Rotation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rot.State = RotationCompleted
+	rot.Err = nil
+	rot.CompletedAt = time.Now()
+	r.pending = slices.DeleteFunc(r.pending, func(p *Rotation) bool { return p == rot })
+}
+
+func (r *TokenRotator) fail(rot *Rotation, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rot.Err = err
+	rot.CompletedAt = time.Now()
+
+	return err
+}
+
+func (r *TokenRotator) store(ctx context.Context, secret string) error {
+	for _, sink := range r.sinks {
+		if err := sink.StoreSecret(ctx, secret); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *TokenRotator) revoke(ctx context.Context, tok *AccessToken) error {
+	return r.client.AccessTokenRevoke(ctx, &AccessTokenRevokeInput{AccessTokenID: &tok.ID})
+}
+
+func (r *TokenRotator) revokeNew(ctx context.Context, tok *AccessToken) error {
+	if err := r.revoke(ctx, tok); err != nil {
+		return fmt.Errorf("revoke new token: %w", err)
+	}
+
+	return nil
+}
+
+// next is the time until the current token is due for rotation.
+func (r *TokenRotator) next() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.current == nil {
+		return 0
+	}
+
+	if _, expires, err := accessTokenSecret(r.current); err == nil && !expires.IsZero() {
+		return max(time.Until(expires.Add(-r.before)), 0)
+	}
+
+	return max(time.Until(r.since.Add(r.interval)), 0)
+}
+
+// accessTokenSecret returns the bearer value and expiry of a token; the
+// expiry is zero for tokens that don't expire.
+func accessTokenSecret(tok *AccessToken) (string, time.Time, error) {
+	if tok.Token == "" {
+		return "", time.Time{}, errors.New("access token has no token value")
+	}
+
+	var expires time.Time
+	if tok.ExpiresAt != nil {
+		expires = *tok.ExpiresAt
+	}
+
+	return tok.Token, expires, nil
+}
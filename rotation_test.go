@@ -0,0 +1,296 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	oldTokenID = "7d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c01"
+	newTokenID = "7d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c02"
+)
+
+// tokenAPI creates newTokenID and fails the first failRevokes revokes.
+type tokenAPI struct {
+	mu          sync.Mutex
+	created     int
+	revoked     []string
+	failRevokes int
+	done        chan struct{}
+}
+
+func (a *tokenAPI) handler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if r.Method == http.MethodDelete {
+		a.revoked = append(a.revoked, r.URL.Path)
+
+		if len(a.revoked) <= a.failRevokes {
+			writeTestJSON(w, http.StatusServiceUnavailable, map[string]any{})
+			return
+		}
+
+		if a.done != nil {
+			close(a.done)
+			a.done = nil
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	a.created++
+	writeTestJSON(w, http.StatusOK, map[string]any{
+		"id":         newTokenID,
+		"token":      fmt.Sprintf("secret-%d", a.created),
+		"expires_at": time.Now().Add(48 * time.Hour),
+	})
+}
+
+func oldToken(t *testing.T) *AccessToken {
+	return &AccessToken{ID: *testID(t, oldTokenID), Token: "secret-0"}
+}
+
+func TestRotateStoresAndRevokes(t *testing.T) {
+	api := &tokenAPI{}
+	c := newTestClient(t, api.handler)
+
+	dir := t.TempDir()
+	env := filepath.Join(dir, ".env")
+	os.WriteFile(env, []byte("A=1\nexport ATOMIC_TOKEN=secret-0\nB=2\n"), 0o600)
+
+	r := NewTokenRotator(c, AccessTokenCreateInput{ApplicationID: testID(t, testAppID)}, oldToken(t),
+		WithSecretSinks(EnvFileSecretSink{Path: env, Name: "ATOMIC_TOKEN"}, FileSecretSink{Path: filepath.Join(dir, "token")}),
+		WithRotationOverlap(time.Millisecond),
+		WithRotateBefore(24*time.Hour))
+
+	rot, err := r.Rotate(context.Background())
+	if err != nil || rot.State != RotationCompleted {
+		t.Fatalf("rotation = %+v, err = %v", rot, err)
+	}
+
+	if len(api.revoked) != 1 || !strings.HasSuffix(api.revoked[0], oldTokenID) {
+		t.Errorf("revoked = %v", api.revoked)
+	}
+
+	if data, _ := os.ReadFile(env); string(data) != "A=1\nATOMIC_TOKEN=secret-1\nB=2\n" {
+		t.Errorf("env file = %q", data)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "token")); string(data) != "secret-1" {
+		t.Errorf("token file = %q", data)
+	}
+
+	// the typed expiry of the new token decides the next rotation
+	if next := r.next(); next < 23*time.Hour || next > 24*time.Hour {
+		t.Errorf("next rotation in %v", next)
+	}
+}
+
+func TestRotateSinkFailureRestores(t *testing.T) {
+	api := &tokenAPI{}
+	c := newTestClient(t, api.handler)
+
+	file := filepath.Join(t.TempDir(), "token")
+
+	r := NewTokenRotator(c, AccessTokenCreateInput{ApplicationID: testID(t, testAppID)}, oldToken(t),
+		WithSecretSinks(FileSecretSink{Path: file}, SecretSinkFunc(func(_ context.Context, secret string) error {
+			if secret != "secret-0" {
+				return errors.New("sink unavailable")
+			}
+			return nil
+		})))
+
+	rot, err := r.Rotate(context.Background())
+	if err == nil || rot.State != RotationFailed {
+		t.Fatalf("rotation = %+v, err = %v", rot, err)
+	}
+
+	// the new token is revoked and the old one kept everywhere
+	if len(api.revoked) != 1 || !strings.HasSuffix(api.revoked[0], newTokenID) || r.Current().Token != "secret-0" {
+		t.Errorf("revoked = %v, current = %v", api.revoked, r.Current())
+	}
+
+	if data, _ := os.ReadFile(file); string(data) != "secret-0" {
+		t.Errorf("token file = %q", data)
+	}
+}
+
+func TestRunRetriesOnlyTheRevoke(t *testing.T) {
+	done := make(chan struct{})
+	api := &tokenAPI{failRevokes: 2, done: done}
+
+	c := newTestClient(t, api.handler, WithRetryPolicy(RetryPolicy{}))
+
+	r := NewTokenRotator(c, AccessTokenCreateInput{ApplicationID: testID(t, testAppID)}, oldToken(t),
+		WithRotationOverlap(time.Millisecond),
+		WithRotationInterval(time.Hour),
+		WithRotationRetry(time.Millisecond))
+
+	// the old token is overdue
+	r.since = time.Now().Add(-2 * time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() { errc <- r.Run(ctx) }()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("the old token was never revoked")
+	}
+
+	// let Run record the revoke
+	for r.hasPending() && ctx.Err() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-errc
+
+	if api.created != 1 {
+		t.Errorf("created %d tokens, want 1", api.created)
+	}
+
+	for _, path := range api.revoked {
+		if !strings.HasSuffix(path, oldTokenID) {
+			t.Errorf("revoked %s", path)
+		}
+	}
+
+	history := r.History()
+	if len(history) != 1 || history[0].State != RotationCompleted || history[0].Err != nil {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func TestRollbackPendingRotation(t *testing.T) {
+	api := &tokenAPI{failRevokes: 1}
+	c := newTestClient(t, api.handler, WithRetryPolicy(RetryPolicy{}))
+
+	r := NewTokenRotator(c, AccessTokenCreateInput{ApplicationID: testID(t, testAppID)}, oldToken(t), WithRotationOverlap(time.Millisecond))
+
+	rot, err := r.Rotate(context.Background())
+	if err == nil || rot.State != RotationStored || !r.hasPending() {
+		t.Fatalf("rotation = %+v, err = %v", rot, err)
+	}
+
+	if err := r.Rollback(context.Background(), rot); err != nil {
+		t.Fatal(err)
+	}
+
+	if rot.State != RotationRolledBack || r.hasPending() || r.Current().Token != "secret-0" {
+		t.Errorf("rotation = %+v, pending = %v", rot, r.hasPending())
+	}
+}
+
+func TestRotateCanceledDuringOverlapStaysPending(t *testing.T) {
+	api := &tokenAPI{}
+	c := newTestClient(t, api.handler)
+
+	r := NewTokenRotator(c, AccessTokenCreateInput{ApplicationID: testID(t, testAppID)}, oldToken(t), WithRotationOverlap(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	rot, err := r.Rotate(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || rot.State != RotationStored || !r.hasPending() {
+		t.Fatalf("rotation = %+v, err = %v, pending = %v", rot, err, r.hasPending())
+	}
+
+	// Run revokes the old token later
+	if err := r.revokePending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if rot.State != RotationCompleted || len(api.revoked) != 1 || !strings.HasSuffix(api.revoked[0], oldTokenID) {
+		t.Errorf("rotation = %+v, revoked = %v", rot, api.revoked)
+	}
+}
+
+func TestRollbackDuringOverlap(t *testing.T) {
+	api := &tokenAPI{}
+	c := newTestClient(t, api.handler)
+
+	r := NewTokenRotator(c, AccessTokenCreateInput{ApplicationID: testID(t, testAppID)}, oldToken(t), WithRotationOverlap(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := r.Rotate(ctx)
+		errc <- err
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Current().Token != "secret-1" {
+		if time.Now().After(deadline) {
+			t.Fatal("the new token was never stored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rot := r.History()[0]
+
+	rctx, rcancel := context.WithTimeout(context.Background(), time.Second)
+	defer rcancel()
+
+	if err := r.Rollback(rctx, rot); err != nil {
+		t.Fatalf("rollback during the overlap: %v", err)
+	}
+
+	cancel()
+	<-errc
+
+	if r.Current().Token != "secret-0" || r.hasPending() {
+		t.Errorf("current = %v, pending = %v", r.Current(), r.hasPending())
+	}
+
+	// only the new token is revoked
+	if len(api.revoked) != 1 || !strings.HasSuffix(api.revoked[0], newTokenID) {
+		t.Errorf("revoked = %v", api.revoked)
+	}
+}
+
+func TestRotateReportsFailedRestore(t *testing.T) {
+	api := &tokenAPI{}
+	c := newTestClient(t, api.handler)
+
+	r := NewTokenRotator(c, AccessTokenCreateInput{ApplicationID: testID(t, testAppID)}, oldToken(t),
+		WithSecretSinks(SecretSinkFunc(func(context.Context, string) error {
+			return errors.New("sink unavailable")
+		})))
+
+	_, err := r.Rotate(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "restore old token: sink unavailable") {
+		t.Errorf("err = %v", err)
+	}
+}
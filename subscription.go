@@ -20,6 +20,7 @@ package atomic
 import (
 	"context"
 	"fmt"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -69,6 +70,12 @@ func (c *Client) SubscriptionList(ctx context.Context, params *SubscriptionListI
 	return resp.Value(), nil
 }
 
+func (c *Client) SubscriptionListAll(ctx context.Context, params *SubscriptionListInput, opts ...PageOption) iter.Seq2[*Subscription, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*Subscription, error) {
+		return c.SubscriptionList(ctx, params)
+	})
+}
+
 func (c *Client) SubscriptionCreate(ctx context.Context, params *SubscriptionCreateInput) (*Subscription, error) {
 	var resp ResponseProxy[Subscription]
 
@@ -20,6 +20,7 @@ package atomic
 import (
 	"context"
 	"fmt"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -69,6 +70,12 @@ func (c *Client) TemplateList(ctx context.Context, params *TemplateListInput) ([
 	return resp.Value(), nil
 }
 
+func (c *Client) TemplateListAll(ctx context.Context, params *TemplateListInput, opts ...PageOption) iter.Seq2[*Template, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*Template, error) {
+		return c.TemplateList(ctx, params)
+	})
+}
+
 func (c *Client) TemplateCreate(ctx context.Context, params *TemplateCreateInput) (*Template, error) {
 	var resp ResponseProxy[Template]
 
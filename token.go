@@ -0,0 +1,379 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+type (
+	// tokenSource caches the token used to authorize requests and can be forced
+	// to fetch a new one when the server rejects it.
+	tokenSource struct {
+		mu sync.Mutex
+		// fetch obtains a new token; rejected is set when prev was refused
+		// by the server
+		fetch  func(ctx context.Context, prev *oauth2.Token, rejected bool) (*oauth2.Token, error)
+		tok    *oauth2.Token
+		notify func(*oauth2.Token)
+		cache  TokenCache
+		key    string
+	}
+
+	// TokenRefresher is a token source that can replace a token the server
+	// rejected before it expired. Sources given to WithTokenSource that
+	// implement it are asked to refresh after a 401.
+	TokenRefresher interface {
+		oauth2.TokenSource
+		Refresh(ctx context.Context, rejected *oauth2.Token) (*oauth2.Token, error)
+	}
+
+	// refreshTokenSource keeps a token fresh with its refresh token, both
+	// when it expires and when it is rejected.
+	refreshTokenSource struct {
+		mu  sync.Mutex
+		ctx context.Context
+		cfg *oauth2.Config
+		tok *oauth2.Token
+	}
+)
+
+var (
+	// ErrTokenNotRefreshed is returned when a rejected token could not be
+	// replaced by a different one.
+	ErrTokenNotRefreshed = errors.New("token source returned the rejected token")
+)
+
+// WithTokenSource authorizes requests with tokens from ts. Tokens are cached
+// until they expire; after a 401 a TokenRefresher is asked to refresh, any
+// other source for a new token, and the request is retried once. A token the
+// source hands out again is dropped, so the next call asks the source anew.
+func WithTokenSource(ts oauth2.TokenSource) ApiOption {
+	return func(c *ApiConfig) {
+		c.TokenSource = ts
+	}
+}
+
+// WithClientCredentials authorizes requests with the client credentials
+// grant. The token source is built once all options are applied, so the
+// token URL follows the final host and http client.
+func WithClientCredentials(clientID, clientSecret string, scopes ...string) ApiOption {
+	return func(c *ApiConfig) {
+		c.clientCredentials = &clientcredentials.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Scopes:       scopes,
+		}
+		c.clientSecrets = append(c.clientSecrets, clientSecret)
+	}
+}
+
+// WithRefreshToken authorizes requests with a token obtained from a user
+// login, refreshing it with cfg when it expires or is rejected. If the
+// endpoint token URL is empty the API host's token endpoint is used.
+func WithRefreshToken(cfg *oauth2.Config, token *oauth2.Token) ApiOption {
+	return func(c *ApiConfig) {
+		c.refreshConfig = cfg
+		c.refreshToken = token
+		if cfg.ClientSecret != "" {
+			c.clientSecrets = append(c.clientSecrets, cfg.ClientSecret)
+		}
+	}
+}
+
+// WithTokenNotify calls fn with every new token the client obtains, e.g. to
+// persist rotated refresh tokens.
+func WithTokenNotify(fn func(*oauth2.Token)) ApiOption {
+	return func(c *ApiConfig) {
+		c.tokenNotify = fn
+	}
+}
+
+// tokenURL is the token endpoint under the base URL.
+func (c ApiConfig) tokenURL() string {
+	return Endpoint(c.resolvedBaseURL()).TokenURL
+}
+
+// resolvedBaseURL is the base URL requests and tokens belong to.
+func (c ApiConfig) resolvedBaseURL() string {
+	if c.base != nil {
+		return c.base.String()
+	}
+
+	return cmp.Or(c.BaseURL, c.Host)
+}
+
+// newTokenSource builds the token source from the configured credentials, in
+// order of precedence: an explicit source, a refresh token, then client
+// credentials. It returns nil when requests use the static access token.
+func (c *ApiConfig) newTokenSource() *tokenSource {
+	ts := &tokenSource{
+		notify: c.tokenNotify,
+	}
+
+	switch {
+	case c.TokenSource != nil:
+		src := c.TokenSource
+		ts.fetch = func(ctx context.Context, prev *oauth2.Token, rejected bool) (*oauth2.Token, error) {
+			if r, ok := src.(TokenRefresher); ok && rejected && prev != nil {
+				return r.Refresh(ctx, prev)
+			}
+			return src.Token()
+		}
+
+	case c.refreshConfig != nil:
+		cfg := *c.refreshConfig
+		if cfg.Endpoint.TokenURL == "" {
+			cfg.Endpoint.TokenURL = c.tokenURL()
+		}
+
+		ts.tok = c.refreshToken
+		ts.cache = c.TokenCache
+		ts.key = TokenCacheKey(c.resolvedBaseURL(), cfg.ClientID, cfg.Scopes)
+		ts.fetch = func(ctx context.Context, prev *oauth2.Token, _ bool) (*oauth2.Token, error) {
+			if prev == nil || prev.RefreshToken == "" {
+				return nil, errors.New("no refresh token available")
+			}
+
+			// a token without an access token is always refreshed
+			return cfg.TokenSource(c.oauthContext(ctx), &oauth2.Token{RefreshToken: prev.RefreshToken}).Token()
+		}
+
+	case c.clientCredentials != nil:
+		cfg := *c.clientCredentials
+		cfg.TokenURL = c.tokenURL()
+
+		ts.cache = c.TokenCache
+		ts.key = TokenCacheKey(c.resolvedBaseURL(), cfg.ClientID, cfg.Scopes)
+		ts.fetch = func(ctx context.Context, _ *oauth2.Token, _ bool) (*oauth2.Token, error) {
+			return cfg.Token(c.oauthContext(ctx))
+		}
+
+	default:
+		return nil
+	}
+
+	return ts
+}
+
+// oauthContext makes token requests use the configured http client, routed
+// through the endpoint pool when the base URL is its primary endpoint.
+func (c *ApiConfig) oauthContext(ctx context.Context) context.Context {
+	client := c.http
+
+	if c.EndpointPool != nil && c.base == c.EndpointPool.primary() {
+		pooled := *cmp.Or(client, http.DefaultClient)
+		pooled.Transport = &poolTransport{pool: c.EndpointPool, next: pooled.Transport}
+		client = &pooled
+	}
+
+	return withHTTPClient(ctx, client)
+}
+
+// Token implements oauth2.TokenSource.
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	return s.token(context.Background())
+}
+
+func (s *tokenSource) token(ctx context.Context) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tok.Valid() {
+		return s.tok, nil
+	}
+
+	return s.load(ctx, "")
+}
+
+// refresh replaces the rejected access token, unless another caller has
+// already done so.
+func (s *tokenSource) refresh(ctx context.Context, rejected string) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tok.Valid() && s.tok.AccessToken != rejected {
+		return s.tok, nil
+	}
+
+	tok, err := s.load(ctx, rejected)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.AccessToken == rejected {
+		// forget it, so the next call asks the source again
+		s.tok = &oauth2.Token{RefreshToken: tok.RefreshToken}
+		return nil, ErrTokenNotRefreshed
+	}
+
+	return tok, nil
+}
+
+// load takes a token from the cache, fetching and caching a new one when it
+// holds none or only the rejected one.
+func (s *tokenSource) load(ctx context.Context, rejected string) (*oauth2.Token, error) {
+	if s.cache == nil {
+		return s.update(ctx, rejected != "")
+	}
+
+	if tok := s.cached(ctx, rejected); tok != nil {
+		return tok, nil
+	}
+
+	if l, ok := s.cache.(TokenCacheLocker); ok {
+		if unlock, err := l.Lock(ctx, s.key); err == nil {
+			defer unlock()
+
+			// another process may have fetched one while we waited
+			if tok := s.cached(ctx, rejected); tok != nil {
+				return tok, nil
+			}
+		} else if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	tok, err := s.update(ctx, rejected != "")
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Put(ctx, s.key, tok)
+
+	return tok, nil
+}
+
+// cached returns a usable token from the cache; an expired one is kept as the
+// previous token so its refresh token is used.
+func (s *tokenSource) cached(ctx context.Context, rejected string) *oauth2.Token {
+	tok, err := s.cache.Get(ctx, s.key)
+	if err != nil || tok == nil {
+		return nil
+	}
+
+	if tok.RefreshToken != "" {
+		s.tok = tok
+	}
+
+	if !tok.Valid() || tok.AccessToken == rejected {
+		return nil
+	}
+
+	s.tok = tok
+
+	return tok
+}
+
+func (s *tokenSource) update(ctx context.Context, rejected bool) (*oauth2.Token, error) {
+	tok, err := s.fetch(ctx, s.tok, rejected)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.RefreshToken == "" && s.tok != nil {
+		tok.RefreshToken = s.tok.RefreshToken
+	}
+
+	s.tok = tok
+
+	if s.notify != nil {
+		s.notify(tok)
+	}
+
+	return tok, nil
+}
+
+func (s *tokenSource) current() *oauth2.Token {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.tok
+}
+
+func newRefreshTokenSource(ctx context.Context, cfg *oauth2.Config, tok *oauth2.Token) *refreshTokenSource {
+	return &refreshTokenSource{
+		ctx: ctx,
+		cfg: cfg,
+		tok: tok,
+	}
+}
+
+// Token implements oauth2.TokenSource.
+func (s *refreshTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tok.Valid() {
+		return s.tok, nil
+	}
+
+	return s.refresh()
+}
+
+// Refresh implements TokenRefresher; like Token it uses the context the
+// source was created with, which carries the login's http client.
+func (s *refreshTokenSource) Refresh(_ context.Context, rejected *oauth2.Token) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// another caller may have refreshed it already
+	if s.tok.Valid() && s.tok.AccessToken != rejected.AccessToken {
+		return s.tok, nil
+	}
+
+	return s.refresh()
+}
+
+func (s *refreshTokenSource) refresh() (*oauth2.Token, error) {
+	if s.tok == nil || s.tok.RefreshToken == "" {
+		return nil, errors.New("no refresh token available")
+	}
+
+	// a token without an access token is always refreshed
+	tok, err := s.cfg.TokenSource(s.ctx, &oauth2.Token{RefreshToken: s.tok.RefreshToken}).Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = s.tok.RefreshToken
+	}
+
+	s.tok = tok
+
+	return tok, nil
+}
+
+// bearerToken returns the credential of an Authorization header.
+func bearerToken(header string) string {
+	_, tok, _ := strings.Cut(header, " ")
+	return tok
+}
@@ -0,0 +1,241 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+type (
+	// TokenCache stores tokens between token source instances, and with a
+	// shared backing store, between processes. Get returns nil for a missing
+	// key.
+	TokenCache interface {
+		Get(ctx context.Context, key string) (*oauth2.Token, error)
+		Put(ctx context.Context, key string, tok *oauth2.Token) error
+	}
+
+	// TokenCacheLocker is implemented by caches shared between processes; the
+	// lock is held while a new token is fetched so only one process calls the
+	// token endpoint.
+	TokenCacheLocker interface {
+		Lock(ctx context.Context, key string) (func(), error)
+	}
+
+	MemoryTokenCache struct {
+		mu     sync.Mutex
+		tokens map[string]*oauth2.Token
+	}
+
+	// FileTokenCache keeps one AES-GCM encrypted file per key in a directory.
+	FileTokenCache struct {
+		dir  string
+		aead cipher.AEAD
+	}
+)
+
+const (
+	tokenCacheInfo     = "atomic-go token cache"
+	tokenCacheSaltSize = 32
+)
+
+// WithTokenCache caches the tokens of WithClientCredentials and
+// WithRefreshToken under TokenCacheKey. Cache failures are not fatal, the
+// token endpoint is used instead.
+func WithTokenCache(cache TokenCache) ApiOption {
+	return func(c *ApiConfig) {
+		c.TokenCache = cache
+	}
+}
+
+// TokenCacheKey identifies the tokens of a client at an API base URL for a
+// set of scopes, in any order.
+func TokenCacheKey(baseURL, clientID string, scopes []string) string {
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+
+	return baseURL + "|" + clientID + "|" + strings.Join(scopes, " ")
+}
+
+func NewMemoryTokenCache() *MemoryTokenCache {
+	return &MemoryTokenCache{
+		tokens: make(map[string]*oauth2.Token),
+	}
+}
+
+func (m *MemoryTokenCache) Get(_ context.Context, key string) (*oauth2.Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.tokens[key], nil
+}
+
+func (m *MemoryTokenCache) Put(_ context.Context, key string, tok *oauth2.Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tokens[key] = tok
+
+	return nil
+}
+
+// DefaultTokenCacheDir is the per-user directory for FileTokenCache.
+func DefaultTokenCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "atomic", "tokens"), nil
+}
+
+// NewFileTokenCache creates a cache in dir whose files are encrypted with a
+// key derived from secret by HKDF, salted with a random value kept in dir.
+func NewFileTokenCache(dir string, secret []byte) (*FileTokenCache, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("token cache secret is required")
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	salt, err := tokenCacheSalt(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hkdf.Key(sha256.New, secret, salt, tokenCacheInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileTokenCache{
+		dir:  dir,
+		aead: aead,
+	}, nil
+}
+
+func (f *FileTokenCache) Get(_ context.Context, key string) (*oauth2.Token, error) {
+	data, err := os.ReadFile(f.path(key, ".token"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	size := f.aead.NonceSize()
+	if len(data) < size {
+		return nil, errors.New("token cache file is truncated")
+	}
+
+	// the key is bound as additional data so files can't be swapped
+	plain, err := f.aead.Open(nil, data[:size], data[size:], []byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	var tok oauth2.Token
+	if err := json.Unmarshal(plain, &tok); err != nil {
+		return nil, err
+	}
+
+	return &tok, nil
+}
+
+func (f *FileTokenCache) Put(_ context.Context, key string, tok *oauth2.Token) error {
+	plain, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, f.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	data := f.aead.Seal(nonce, nonce, plain, []byte(key))
+
+	return writeFile(f.path(key, ".token"), data, 0o600)
+}
+
+// Lock takes an exclusive file lock for key, waiting until it is free or ctx
+// is done.
+func (f *FileTokenCache) Lock(ctx context.Context, key string) (func(), error) {
+	return lockFile(ctx, f.path(key, ".lock"))
+}
+
+// tokenCacheSalt reads the salt of the cache in dir, creating it on first
+// use; the lock keeps concurrent processes from picking different ones.
+func tokenCacheSalt(dir string) ([]byte, error) {
+	path := filepath.Join(dir, "salt")
+
+	unlock, err := lockFile(context.Background(), path+".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	salt, err := os.ReadFile(path)
+	if err == nil && len(salt) == tokenCacheSaltSize {
+		return salt, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	salt = make([]byte, tokenCacheSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	if err := writeFile(path, salt, 0o600); err != nil {
+		return nil, err
+	}
+
+	return salt, nil
+}
+
+func (f *FileTokenCache) path(key, ext string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:16])+ext)
+}
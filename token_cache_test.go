@@ -0,0 +1,170 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// newIssuingServer issues <prefix>1, <prefix>2, ... and accepts only its own
+// tokens.
+func newIssuingServer(t *testing.T, prefix string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var issued atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth/token" {
+			time.Sleep(10 * time.Millisecond)
+			writeTestJSON(w, http.StatusOK, map[string]any{
+				"access_token": fmt.Sprintf("%s%d", prefix, issued.Add(1)),
+				"token_type":   "Bearer",
+				"expires_in":   3600,
+			})
+			return
+		}
+
+		if tok := bearerToken(r.Header.Get("Authorization")); len(tok) <= len(prefix) || tok[:len(prefix)] != prefix {
+			writeTestJSON(w, http.StatusUnauthorized, map[string]any{"code": "unauthorized"})
+			return
+		}
+		writeTestJSON(w, http.StatusOK, map[string]any{})
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &issued
+}
+
+func TestFileTokenCacheSharedAcrossProcesses(t *testing.T) {
+	srv, issued := newIssuingServer(t, "t")
+
+	dir := t.TempDir()
+
+	var wg sync.WaitGroup
+
+	// every client opens the directory on its own, like separate processes
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			cache, err := NewFileTokenCache(dir, []byte("secret"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			c := New(WithBaseURL(srv.URL), WithClientCredentials("client", "s", "b", "a"), WithTokenCache(cache))
+			if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if n := issued.Load(); n != 1 {
+		t.Errorf("issued %d tokens", n)
+	}
+}
+
+func TestFileTokenCacheKeyDerivation(t *testing.T) {
+	ctx := context.Background()
+	key := TokenCacheKey("https://api.atomic.com", "client", nil)
+	tok := &oauth2.Token{AccessToken: "t1", Expiry: time.Now().Add(time.Hour)}
+
+	dir := t.TempDir()
+
+	cache, err := NewFileTokenCache(dir, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Put(ctx, key, tok); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := cache.Get(ctx, key); err != nil || got.AccessToken != "t1" {
+		t.Fatalf("get = %v, %v", got, err)
+	}
+
+	// the salt survives reopening the directory
+	if reopened, err := NewFileTokenCache(dir, []byte("secret")); err != nil {
+		t.Fatal(err)
+	} else if got, err := reopened.Get(ctx, key); err != nil || got.AccessToken != "t1" {
+		t.Fatalf("reopened get = %v, %v", got, err)
+	}
+
+	if wrong, _ := NewFileTokenCache(dir, []byte("other")); wrong != nil {
+		if _, err := wrong.Get(ctx, key); err == nil {
+			t.Error("decrypted with the wrong secret")
+		}
+	}
+
+	// the same secret in another directory has another salt, so its key
+	// can't open these files
+	other := t.TempDir()
+
+	data, err := os.ReadFile(cache.path(key, ".token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(other, filepath.Base(cache.path(key, ".token"))), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := NewFileTokenCache(other, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := moved.Get(ctx, key); err == nil {
+		t.Error("decrypted with the key of another directory")
+	}
+}
+
+func TestTokenCacheKeyedByBaseURL(t *testing.T) {
+	a, issuedA := newIssuingServer(t, "a")
+	b, issuedB := newIssuingServer(t, "b")
+
+	cache := NewMemoryTokenCache()
+
+	for _, srv := range []*httptest.Server{a, b, a, b} {
+		c := New(WithBaseURL(srv.URL), WithClientCredentials("client", "s"), WithTokenCache(cache))
+
+		if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if issuedA.Load() != 1 || issuedB.Load() != 1 {
+		t.Errorf("issued a = %d, b = %d", issuedA.Load(), issuedB.Load())
+	}
+}
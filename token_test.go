@@ -0,0 +1,203 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+type (
+	// tokenServer issues t1, t2, ... from /oauth/token and accepts only the
+	// tokens in valid on the API, answering the first unavailable accepted
+	// calls with a 503
+	tokenServer struct {
+		*httptest.Server
+
+		mu          sync.Mutex
+		issued      int
+		grants      []string
+		valid       map[string]bool
+		calls       int
+		unavailable int
+	}
+
+	countingSource struct {
+		tok   *oauth2.Token
+		calls int
+	}
+)
+
+func newTokenServer(t *testing.T, valid ...string) *tokenServer {
+	t.Helper()
+
+	s := &tokenServer{valid: make(map[string]bool)}
+	for _, v := range valid {
+		s.valid[v] = true
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if r.URL.Path == "/oauth/token" {
+			r.ParseForm()
+			s.issued++
+			s.grants = append(s.grants, r.Form.Get("grant_type")+":"+r.Form.Get("refresh_token"))
+			writeTestJSON(w, http.StatusOK, map[string]any{
+				"access_token":  fmt.Sprintf("t%d", s.issued),
+				"refresh_token": fmt.Sprintf("r%d", s.issued),
+				"token_type":    "Bearer",
+				"expires_in":    3600,
+			})
+			return
+		}
+
+		s.calls++
+		if !s.valid[bearerToken(r.Header.Get("Authorization"))] {
+			writeTestJSON(w, http.StatusUnauthorized, map[string]any{"code": "unauthorized"})
+			return
+		}
+		if s.unavailable > 0 {
+			s.unavailable--
+			writeTestJSON(w, http.StatusServiceUnavailable, map[string]any{"code": "unavailable"})
+			return
+		}
+		writeTestJSON(w, http.StatusOK, map[string]any{})
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *countingSource) Token() (*oauth2.Token, error) {
+	s.calls++
+	return s.tok, nil
+}
+
+func TestTokenRefreshAfterUnauthorized(t *testing.T) {
+	srv := newTokenServer(t, "t2")
+
+	c := New(WithBaseURL(srv.URL), WithClientCredentials("client", "secret"))
+
+	if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if srv.issued != 2 || srv.calls != 2 {
+		t.Errorf("issued = %d, calls = %d", srv.issued, srv.calls)
+	}
+}
+
+func TestTokenRefreshDoesNotUseRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		retries     int
+		unavailable int
+	}{
+		{"no retries", 0, 0},
+		{"retry after refresh", 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTokenServer(t, "t2")
+			srv.unavailable = tt.unavailable
+
+			c := New(WithBaseURL(srv.URL), WithClientCredentials("client", "secret"),
+				WithRetryPolicy(testRetryPolicy(0)), WithMaxRetries(tt.retries))
+
+			var resp Response
+			ctx := ContextWithResponse(context.Background(), &resp)
+
+			if _, err := c.UserGet(ctx, &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+				t.Fatal(err)
+			}
+
+			if want := 2 + tt.unavailable; srv.calls != want || resp.Attempts != want {
+				t.Errorf("calls = %d, attempts = %d, want %d", srv.calls, resp.Attempts, want)
+			}
+		})
+	}
+}
+
+func TestLoginTokenSourceRefreshesRejectedToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		source func(host string, tok *oauth2.Token) oauth2.TokenSource
+	}{
+		{"auth code", func(host string, tok *oauth2.Token) oauth2.TokenSource {
+			return NewAuthCodeLogin(AuthCodeConfig{Host: host, ClientID: "client"}).TokenSource(context.Background(), tok)
+		}},
+		{"device", func(host string, tok *oauth2.Token) oauth2.TokenSource {
+			return NewDeviceLogin(DeviceConfig{Host: host, ClientID: "client"}).TokenSource(context.Background(), tok)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTokenServer(t, "t1")
+
+			// still valid by its expiry, but revoked on the server
+			tok := &oauth2.Token{AccessToken: "t0", RefreshToken: "r0", Expiry: time.Now().Add(time.Hour)}
+
+			c := New(WithBaseURL(srv.URL), WithTokenSource(tt.source(srv.URL, tok)))
+
+			if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(srv.grants) != 1 || srv.grants[0] != "refresh_token:r0" || srv.calls != 2 {
+				t.Errorf("grants = %v, calls = %d", srv.grants, srv.calls)
+			}
+		})
+	}
+}
+
+func TestTokenSourceRejectedIsDropped(t *testing.T) {
+	srv := newTokenServer(t)
+
+	src := &countingSource{tok: &oauth2.Token{AccessToken: "stale", Expiry: time.Now().Add(time.Hour)}}
+
+	c := New(WithBaseURL(srv.URL), WithTokenSource(src))
+
+	_, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)})
+	if !errors.Is(err, ErrUnauthorized) || srv.calls != 1 {
+		t.Fatalf("err = %v, calls = %d", err, srv.calls)
+	}
+
+	// the source now hands out a working token, which the next call picks up
+	src.tok = &oauth2.Token{AccessToken: "fresh", Expiry: time.Now().Add(time.Hour)}
+	srv.valid["fresh"] = true
+
+	if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if src.calls != 3 {
+		t.Errorf("source called %d times", src.calls)
+	}
+}
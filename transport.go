@@ -0,0 +1,151 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WithBaseURL points the client at a full base URL, including the scheme,
+// port and any path prefix, e.g. "http://localhost:9000" or
+// "https://gateway.example.com/atomic". It takes precedence over WithHost.
+func WithBaseURL(rawURL string) ApiOption {
+	return func(c *ApiConfig) {
+		c.BaseURL = rawURL
+	}
+}
+
+// WithRootCAs verifies the server against pool instead of the system roots.
+func WithRootCAs(pool *x509.CertPool) ApiOption {
+	return func(c *ApiConfig) {
+		c.RootCAs = pool
+	}
+}
+
+// WithClientCertificate presents cert to the server for mutual TLS.
+func WithClientCertificate(cert tls.Certificate) ApiOption {
+	return func(c *ApiConfig) {
+		c.Certificates = append(c.Certificates, cert)
+	}
+}
+
+// WithUnixSocket sends every request over the unix socket at path; the base
+// URL still sets the scheme, Host header and path prefix.
+func WithUnixSocket(path string) ApiOption {
+	return func(c *ApiConfig) {
+		c.UnixSocket = path
+	}
+}
+
+// baseURL parses a full base URL, or treats s as a host served over https.
+func baseURL(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base url: unsupported scheme %q", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid base url: missing host in %q", s)
+	}
+
+	u.RawQuery, u.Fragment = "", ""
+
+	return u, nil
+}
+
+// resolve applies the url and transport settings once all options are set;
+// a failure is returned by every request.
+func (c *ApiConfig) resolve() {
+	if c.EndpointPool != nil && c.BaseURL == "" {
+		c.base = c.EndpointPool.primary()
+	} else {
+		c.base, c.err = baseURL(cmp.Or(c.BaseURL, c.Host))
+	}
+
+	if c.err != nil {
+		return
+	}
+
+	if c.RootCAs == nil && len(c.Certificates) == 0 && c.UnixSocket == "" {
+		return
+	}
+
+	var t *http.Transport
+
+	switch rt := c.http.Transport.(type) {
+	case nil:
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		t = rt.Clone()
+	default:
+		c.err = fmt.Errorf("tls and unix socket options require an *http.Transport, got %T", rt)
+		return
+	}
+
+	if c.RootCAs != nil || len(c.Certificates) > 0 {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+
+		if c.RootCAs != nil {
+			t.TLSClientConfig.RootCAs = c.RootCAs
+		}
+
+		t.TLSClientConfig.Certificates = append(t.TLSClientConfig.Certificates, c.Certificates...)
+	}
+
+	if c.UnixSocket != "" {
+		socket := c.UnixSocket
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+	}
+
+	client := *c.http
+	client.Transport = t
+	c.http = &client
+}
+
+// url joins a request path to the base URL, or to the endpoint picked for
+// the attempt.
+func (c ApiConfig) url(ctx context.Context, path string) string {
+	base := c.base
+	if u, ok := ctx.Value(endpointContextKey{}).(*url.URL); ok {
+		base = u
+	}
+
+	return strings.TrimSuffix(base.String(), "/") + "/" + strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,186 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pathRecorder answers token requests and API calls and records the paths
+type pathRecorder struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (p *pathRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.paths = append(p.paths, r.URL.Path)
+	p.mu.Unlock()
+
+	if filepath.Base(r.URL.Path) == "token" {
+		writeTestJSON(w, http.StatusOK, map[string]any{
+			"access_token": "issued",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+		return
+	}
+	writeTestJSON(w, http.StatusOK, map[string]any{"id": testUserID})
+}
+
+func TestBaseURLPrefixAndTokenURL(t *testing.T) {
+	rec := &pathRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	c := New(WithBaseURL(srv.URL+"/prefix/"), WithClientCredentials("id", "secret"))
+
+	if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/prefix/oauth/token", "/prefix/api/1.0.0/users/" + testUserID}
+	if len(rec.paths) != 2 || rec.paths[0] != want[0] || rec.paths[1] != want[1] {
+		t.Errorf("paths = %v, want %v", rec.paths, want)
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{in: "api.example.com", want: "https://api.example.com"},
+		{in: "http://localhost:9000/atomic", want: "http://localhost:9000/atomic"},
+		{in: "https://example.com/x?y=1#z", want: "https://example.com/x"},
+		{in: "ftp://example.com", err: true},
+		{in: "http://", err: true},
+	}
+
+	for _, tt := range tests {
+		u, err := baseURL(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("baseURL(%q) = %v, want an error", tt.in, u)
+			}
+			continue
+		}
+		if err != nil || u.String() != tt.want {
+			t.Errorf("baseURL(%q) = %v, %v, want %s", tt.in, u, err, tt.want)
+		}
+	}
+}
+
+func TestInvalidBaseURLFailsRequests(t *testing.T) {
+	c := New(WithBaseURL("ftp://example.com"), WithToken("test-token"))
+
+	if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err == nil {
+		t.Error("expected an error for an unsupported scheme")
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "atomic.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	rec := &pathRecorder{}
+	srv := &http.Server{Handler: rec}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	c := New(WithBaseURL("http://atomic/prefix"), WithUnixSocket(sock), WithToken("test-token"))
+
+	if _, err := c.UserGet(context.Background(), &UserGetInput{UserID: testID(t, testUserID)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.paths) != 1 || rec.paths[0] != "/prefix/api/1.0.0/users/"+testUserID {
+		t.Errorf("paths = %v", rec.paths)
+	}
+}
+
+func TestRootCAsAndClientCertificate(t *testing.T) {
+	srv := httptest.NewUnstartedServer(&pathRecorder{})
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	params := &UserGetInput{UserID: testID(t, testUserID)}
+
+	c := New(WithBaseURL(srv.URL), WithToken("test-token"), WithRetryPolicy(testRetryPolicy(1)))
+	if _, err := c.UserGet(context.Background(), params); err == nil {
+		t.Error("expected an unknown authority error without the root CA")
+	}
+
+	c = New(WithBaseURL(srv.URL), WithToken("test-token"), WithRootCAs(pool), WithRetryPolicy(testRetryPolicy(1)))
+	if _, err := c.UserGet(context.Background(), params); err == nil {
+		t.Error("expected a handshake error without a client certificate")
+	}
+
+	c = New(
+		WithBaseURL(srv.URL),
+		WithToken("test-token"),
+		WithRootCAs(pool),
+		WithClientCertificate(testCertificate(t)),
+	)
+	if _, err := c.UserGet(context.Background(), params); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
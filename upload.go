@@ -0,0 +1,335 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+type (
+	// UploadOptions tune the streaming of file uploads such as UserImport and
+	// AssetCreate; attach them with ContextWithUploadOptions.
+	UploadOptions struct {
+		// Open returns a fresh reader over the payload for every attempt,
+		// which allows the upload to be retried. Without it, payloads that
+		// implement io.ReaderAt (with a known size) or io.Seeker are rewound;
+		// any other payload is sent once.
+		Open func() (io.Reader, error)
+
+		// Progress is called as the payload is sent, total is -1 when the
+		// size is unknown. It restarts from zero on every attempt.
+		Progress func(sent, total int64)
+	}
+
+	// multipartUpload streams a single file part through a pipe-backed
+	// multipart writer, so the payload is never held in memory.
+	multipartUpload struct {
+		ctx      context.Context
+		field    string
+		filename string
+		mimeType string
+		size     int64
+		boundary string
+		payload  io.Reader
+		opts     UploadOptions
+		offset   int64
+		verified bool
+
+		// mu is held by the writer of a stream that reads the shared payload,
+		// so a retry can't rewind it under a previous attempt
+		mu   sync.Mutex
+		used bool
+	}
+
+	// streamBody is a request body produced on demand; the writer goroutine
+	// only starts on the first Read, so bodies that are never sent don't leak.
+	streamBody struct {
+		pr     *io.PipeReader
+		start  func()
+		once   sync.Once
+		size   int64
+		reopen func() (io.ReadCloser, error)
+	}
+
+	progressReader struct {
+		ctx      context.Context
+		r        io.Reader
+		sent     int64
+		total    int64
+		progress func(sent, total int64)
+	}
+
+	countingWriter int64
+
+	eofReader struct{}
+
+	uploadOptionsKey struct{}
+)
+
+var (
+	ErrPayloadConsumed = errors.New("upload payload cannot be re-read")
+)
+
+func ContextWithUploadOptions(ctx context.Context, opts UploadOptions) context.Context {
+	return context.WithValue(ctx, uploadOptionsKey{}, opts)
+}
+
+func uploadOptionsFromContext(ctx context.Context) UploadOptions {
+	opts, _ := ctx.Value(uploadOptionsKey{}).(UploadOptions)
+	return opts
+}
+
+func newMultipartUpload(ctx context.Context, field, filename, mimeType string, size int64, payload io.Reader) *multipartUpload {
+	var b [16]byte
+	rand.Read(b[:])
+
+	u := &multipartUpload{
+		ctx:      ctx,
+		field:    field,
+		filename: filename,
+		mimeType: mimeType,
+		size:     size,
+		boundary: hex.EncodeToString(b[:]),
+		payload:  payload,
+		opts:     uploadOptionsFromContext(ctx),
+	}
+
+	// remember where a seekable payload starts so retries rewind to it
+	if s, ok := payload.(io.Seeker); ok {
+		if off, err := s.Seek(0, io.SeekCurrent); err == nil {
+			u.offset = off
+		}
+	}
+
+	// a declared size is only trusted for Content-Length once the payload
+	// confirms it
+	if n, ok := payloadSize(payload, u.offset); ok {
+		u.size = n
+		u.verified = true
+	}
+
+	return u
+}
+
+// payloadSize reports the remaining size of payloads that know it.
+func payloadSize(payload io.Reader, offset int64) (int64, bool) {
+	switch p := payload.(type) {
+	case interface{ Len() int }:
+		return int64(p.Len()), true
+	case interface{ Stat() (fs.FileInfo, error) }:
+		fi, err := p.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return 0, false
+		}
+		return fi.Size() - offset, true
+	}
+
+	return 0, false
+}
+
+func (u *multipartUpload) ContentType() string {
+	return "multipart/form-data; boundary=" + u.boundary
+}
+
+// Body returns a new stream over the upload, for use with WithBodyFunc.
+func (u *multipartUpload) Body() io.Reader {
+	return u.stream()
+}
+
+func (u *multipartUpload) stream() *streamBody {
+	pr, pw := io.Pipe()
+
+	s := &streamBody{
+		pr:   pr,
+		size: u.contentLength(),
+	}
+
+	if u.rewindable() {
+		s.reopen = func() (io.ReadCloser, error) {
+			return u.stream(), nil
+		}
+	}
+
+	s.start = func() {
+		src, release, err := u.source()
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		go func() {
+			defer release()
+			pw.CloseWithError(u.write(pw, src))
+		}()
+	}
+
+	return s
+}
+
+func (u *multipartUpload) write(w io.Writer, src io.Reader) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(u.boundary); err != nil {
+		return err
+	}
+
+	part, err := mw.CreatePart(u.header())
+	if err != nil {
+		return fmt.Errorf("failed to create multipart part: %w", err)
+	}
+
+	total := u.size
+	if total <= 0 {
+		total = -1
+	}
+
+	pr := &progressReader{
+		ctx:      u.ctx,
+		r:        src,
+		total:    total,
+		progress: u.opts.Progress,
+	}
+
+	if _, err := io.Copy(part, pr); err != nil {
+		return fmt.Errorf("failed to copy file to multipart writer: %w", err)
+	}
+
+	if err := mw.Close(); err != nil {
+		return fmt.Errorf("failed to close multipart writer: %w", err)
+	}
+
+	return nil
+}
+
+func (u *multipartUpload) header() textproto.MIMEHeader {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition",
+		fmt.Sprintf(`form-data; name="%s"; filename="%s"`, u.field, u.filename))
+	h.Set("Content-Type", u.mimeType)
+
+	if u.verified {
+		h.Set("Content-Length", strconv.FormatInt(u.size, 10))
+	}
+
+	return h
+}
+
+// contentLength is the exact size of the encoded body when the payload size
+// is verified, -1 otherwise so the request is sent chunked.
+func (u *multipartUpload) contentLength() int64 {
+	if !u.verified {
+		return -1
+	}
+
+	var cw countingWriter
+	if err := u.write(&cw, eofReader{}); err != nil {
+		return -1
+	}
+
+	return int64(cw) + u.size
+}
+
+func (u *multipartUpload) rewindable() bool {
+	if u.opts.Open != nil {
+		return true
+	}
+
+	if _, ok := u.payload.(io.ReaderAt); ok && u.size > 0 {
+		return true
+	}
+
+	_, ok := u.payload.(io.Seeker)
+
+	return ok
+}
+
+// source returns the payload positioned at its start for a new attempt, and
+// a func to call once the attempt is done with it.
+func (u *multipartUpload) source() (io.Reader, func(), error) {
+	if u.opts.Open != nil {
+		r, err := u.opts.Open()
+		return r, func() {}, err
+	}
+
+	if ra, ok := u.payload.(io.ReaderAt); ok && u.size > 0 {
+		return io.NewSectionReader(ra, u.offset, u.size), func() {}, nil
+	}
+
+	// the payload itself is read, wait for any previous attempt to let go
+	u.mu.Lock()
+
+	if s, ok := u.payload.(io.Seeker); ok {
+		if _, err := s.Seek(u.offset, io.SeekStart); err != nil {
+			u.mu.Unlock()
+			return nil, nil, err
+		}
+		return u.payload, u.mu.Unlock, nil
+	}
+
+	if u.used {
+		u.mu.Unlock()
+		return nil, nil, ErrPayloadConsumed
+	}
+	u.used = true
+
+	return u.payload, u.mu.Unlock, nil
+}
+
+func (s *streamBody) Read(p []byte) (int, error) {
+	s.once.Do(s.start)
+	return s.pr.Read(p)
+}
+
+// Close aborts the writer goroutine, if it was started.
+func (s *streamBody) Close() error {
+	return s.pr.Close()
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.sent += int64(n)
+		if r.progress != nil {
+			r.progress(r.sent, r.total)
+		}
+	}
+
+	return n, err
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+func (eofReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
@@ -0,0 +1,234 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type (
+	// seekOnly hides everything but Read and Seek of the wrapped payload
+	seekOnly struct {
+		io.ReadSeeker
+	}
+
+	uploadRecord struct {
+		contentLength int64
+		partLength    string
+		data          string
+	}
+)
+
+func newUploadClient(t *testing.T, fail int, opts ...ApiOption) (*Client, func() []uploadRecord) {
+	t.Helper()
+
+	var (
+		mu      sync.Mutex
+		records []uploadRecord
+	)
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		rec := uploadRecord{contentLength: r.ContentLength}
+
+		if f, fh, err := r.FormFile("file"); err == nil {
+			data, _ := io.ReadAll(f)
+			rec.data = string(data)
+			rec.partLength = fh.Header.Get("Content-Length")
+		}
+
+		mu.Lock()
+		records = append(records, rec)
+		n := len(records)
+		mu.Unlock()
+
+		if n <= fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		writeTestJSON(w, http.StatusOK, map[string]any{})
+	}, append([]ApiOption{WithIdempotencyKeys()}, opts...)...)
+
+	return c, func() []uploadRecord {
+		mu.Lock()
+		defer mu.Unlock()
+		return records
+	}
+}
+
+func TestUploadContentLength(t *testing.T) {
+	data := strings.Repeat("x", 64<<10)
+
+	file := filepath.Join(t.TempDir(), "payload")
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		name    string
+		payload io.Reader
+		size    int64
+		exact   bool
+	}{
+		{"len", bytes.NewReader([]byte(data)), int64(len(data)), true},
+		// the payload's own size wins over a wrong declared one
+		{"len mismatch", bytes.NewReader([]byte(data)), 10, true},
+		{"stat", f, 0, true},
+		{"declared only", io.MultiReader(strings.NewReader(data)), int64(len(data)), false},
+		{"unknown", io.MultiReader(strings.NewReader(data)), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, records := newUploadClient(t, 0)
+
+			if _, err := c.AssetCreate(context.Background(), &AssetCreateInput{
+				Payload:  tt.payload,
+				Filename: "payload.txt",
+				MimeType: "text/plain",
+				Size:     tt.size,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			rec := records()[0]
+
+			if rec.data != data {
+				t.Errorf("received %d bytes, want %d", len(rec.data), len(data))
+			}
+
+			if exact := rec.contentLength > int64(len(data)); exact != tt.exact {
+				t.Errorf("content length = %d", rec.contentLength)
+			}
+
+			if !tt.exact && rec.contentLength != -1 {
+				t.Errorf("expected a chunked body, content length = %d", rec.contentLength)
+			}
+
+			// the part only declares a verified size
+			want := ""
+			if tt.exact {
+				want = strconv.Itoa(len(data))
+			}
+			if rec.partLength != want {
+				t.Errorf("part content length = %q, want %q", rec.partLength, want)
+			}
+		})
+	}
+}
+
+func TestUploadRetryRewinds(t *testing.T) {
+	data := strings.Repeat("abc", 10<<10)
+
+	var sent int64
+	ctx := ContextWithUploadOptions(context.Background(), UploadOptions{
+		Progress: func(n, total int64) { sent = n },
+	})
+
+	c, records := newUploadClient(t, 1)
+
+	if _, err := c.AssetCreate(ctx, &AssetCreateInput{
+		Payload:  seekOnly{strings.NewReader(data)},
+		Filename: "payload.txt",
+		MimeType: "text/plain",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := records()
+	if len(recs) != 2 || recs[1].data != data {
+		t.Fatalf("records = %d", len(recs))
+	}
+
+	if sent != int64(len(data)) {
+		t.Errorf("progress = %d", sent)
+	}
+}
+
+func TestUploadOneShotNotRetried(t *testing.T) {
+	c, records := newUploadClient(t, 1)
+
+	_, err := c.AssetCreate(context.Background(), &AssetCreateInput{
+		Payload:  io.MultiReader(strings.NewReader("once")),
+		Filename: "payload.txt",
+		MimeType: "text/plain",
+	})
+
+	if err == nil || len(records()) != 1 {
+		t.Fatalf("err = %v, attempts = %d", err, len(records()))
+	}
+}
+
+func TestUploadConcurrentReopen(t *testing.T) {
+	data := strings.Repeat("0123456789", 32<<10)
+
+	u := newMultipartUpload(context.Background(), "file", "payload.txt", "text/plain", 0, seekOnly{strings.NewReader(data)})
+
+	first := u.stream()
+
+	var (
+		wg     sync.WaitGroup
+		bodies = make([]string, 4)
+	)
+
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var rc io.ReadCloser = first
+			if i > 0 {
+				var err error
+				if rc, err = first.reopen(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			defer rc.Close()
+
+			b, err := io.ReadAll(rc)
+			if err != nil {
+				t.Error(err)
+			}
+			bodies[i] = string(b)
+		}()
+	}
+
+	wg.Wait()
+
+	for i, b := range bodies {
+		if b != bodies[0] || !strings.Contains(b, data) {
+			t.Errorf("body %d differs (%d bytes)", i, len(b))
+		}
+	}
+}
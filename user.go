@@ -18,14 +18,10 @@
 package atomic
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io"
-	"mime/multipart"
-	"net/textproto"
-	"strconv"
+	"iter"
 
 	"github.com/libatomic/atomic/pkg/atomic"
 )
@@ -118,6 +114,12 @@ func (c *Client) UserList(ctx context.Context, params *UserListInput) ([]*User,
 	return resp.Value(), nil
 }
 
+func (c *Client) UserListAll(ctx context.Context, params *UserListInput, opts ...PageOption) iter.Seq2[*User, error] {
+	return paginate(ctx, params, opts, func(ctx context.Context) ([]*User, error) {
+		return c.UserList(ctx, params)
+	})
+}
+
 func (c *Client) UserImport(ctx context.Context, params *UserImportInput) (*Job, error) {
 	var resp ResponseProxy[Job]
 
@@ -129,28 +131,7 @@ func (c *Client) UserImport(ctx context.Context, params *UserImportInput) (*Job,
 		return nil, errors.New("filename is required")
 	}
 
-	var body bytes.Buffer
-
-	writer := multipart.NewWriter(&body)
-
-	h := make(textproto.MIMEHeader)
-	h.Set("Content-Disposition",
-		fmt.Sprintf(`form-data; name="%s"; filename="%s"`, "file", params.Filename))
-	h.Set("Content-Type", params.MimeType)
-	h.Set("Content-Length", strconv.FormatInt(params.Size, 10))
-
-	part, err := writer.CreatePart(h)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create multipart part: %w", err)
-	}
-
-	if _, err := io.Copy(part, params.File); err != nil {
-		return nil, fmt.Errorf("failed to copy file to multipart writer: %w", err)
-	}
-
-	if err := writer.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close multipart writer: %w", err)
-	}
+	upload := newMultipartUpload(ctx, "file", params.Filename, params.MimeType, params.Size, params.File)
 
 	// Build query string from json tags since UserImportInput is a POST with
 	// multipart body; params go on the query string, not in the body.
@@ -162,8 +143,8 @@ func (c *Client) UserImport(ctx context.Context, params *UserImportInput) (*Job,
 	if err := c.Backend.ExecContext(
 		ctx,
 		NewRequest(ctx, path, params).Post().
-			WithContentType(writer.FormDataContentType()).
-			WithBody(&body),
+			WithContentType(upload.ContentType()).
+			WithBodyFunc(upload.Body),
 		&resp); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,91 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"errors"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+const (
+	ValidationErrorCode = "validation_failed"
+)
+
+// WithoutValidation disables client-side validation of method params for the
+// client; use Params.SkipValidation to opt out for a single call.
+func WithoutValidation() ApiOption {
+	return func(c *ApiConfig) {
+		c.SkipValidation = true
+	}
+}
+
+// ValidateParams runs the ozzo validation rules of the method params and
+// returns failures as an Error matching ErrValidation, with the per-field
+// messages in Fields.
+func ValidateParams(params RequestContainer) error {
+	if params.RequestParams().SkipValidation {
+		return nil
+	}
+
+	err := validation.Validate(params.MethodParams())
+	if err == nil {
+		return nil
+	}
+
+	// internal errors are bugs in the rules rather than invalid input
+	var ierr validation.InternalError
+	if errors.As(err, &ierr) {
+		return err
+	}
+
+	e := Error{
+		Code:    ValidationErrorCode,
+		Message: err.Error(),
+		err:     err,
+	}
+
+	var verrs validation.Errors
+	if errors.As(err, &verrs) {
+		e.Fields = make(FieldErrors)
+		flattenValidationErrors(e.Fields, "", verrs)
+	}
+
+	return e
+}
+
+func flattenValidationErrors(out FieldErrors, prefix string, verrs validation.Errors) {
+	for k, err := range verrs {
+		if err == nil {
+			continue
+		}
+
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		var nested validation.Errors
+		if errors.As(err, &nested) {
+			flattenValidationErrors(out, key, nested)
+			continue
+		}
+
+		out[key] = err.Error()
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * This file is part of the Passport Atomic Stack (https://github.com/libatomic/atomic).
+ * Copyright (c) 2024 Atomic Publishing.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, version 3.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU
+ * General Public License for more detail
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package atomic
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+type (
+	validateInput struct {
+		Name    string         `json:"name"`
+		Address *validateInner `json:"address,omitempty"`
+	}
+
+	validateInner struct {
+		City string `json:"city"`
+	}
+)
+
+func (i validateInput) Validate() error {
+	return validation.ValidateStruct(&i,
+		validation.Field(&i.Name, validation.Required),
+		validation.Field(&i.Address),
+	)
+}
+
+func (i validateInner) Validate() error {
+	return validation.ValidateStruct(&i, validation.Field(&i.City, validation.Required))
+}
+
+func TestValidationBeforeDispatch(t *testing.T) {
+	calls := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		writeTestJSON(w, http.StatusOK, map[string]any{})
+	})
+
+	ctx := context.Background()
+	in := &validateInput{Address: &validateInner{}}
+
+	err := c.Backend.ExecContext(ctx, NewRequest(ctx, "/things", in).Post(), nil)
+
+	var e Error
+	if !errors.Is(err, ErrValidation) || !errors.As(err, &e) {
+		t.Fatalf("err = %v", err)
+	}
+
+	if e.Fields["name"] == "" || e.Fields["address.city"] == "" {
+		t.Errorf("fields = %v", e.Fields)
+	}
+
+	if e.Retryable() {
+		t.Error("validation errors must not be retryable")
+	}
+
+	if calls != 0 {
+		t.Errorf("invalid params reached the server %d times", calls)
+	}
+}
+
+func TestValidationOptOut(t *testing.T) {
+	calls := 0
+	h := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		writeTestJSON(w, http.StatusOK, map[string]any{})
+	}
+
+	ctx := ContextWithParams(context.Background(), Params{SkipValidation: true})
+
+	c := newTestClient(t, h)
+	if err := c.Backend.ExecContext(ctx, NewRequest(ctx, "/things", &validateInput{}).Post(), nil); err != nil {
+		t.Fatalf("per-request opt out: %v", err)
+	}
+
+	ctx = context.Background()
+
+	c = newTestClient(t, h, WithoutValidation())
+	if err := c.Backend.ExecContext(ctx, NewRequest(ctx, "/things", &validateInput{}).Post(), nil); err != nil {
+		t.Fatalf("per-client opt out: %v", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("calls = %d", calls)
+	}
+}